@@ -10,6 +10,7 @@ import (
 
 	snake "github.com/kuredoro/snake_p2p"
 	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/kuredoro/snake_p2p/protocol/gather"
 	"github.com/libp2p/go-libp2p-core/peer"
 
@@ -19,6 +20,8 @@ import (
 
 func main() {
 	gatherFlag := flag.Int("gather", 0, "create gather point for N players")
+	hiddenFlag := flag.Bool("hidden", false, "do not announce the gather point, print an invite instead")
+	passphraseFlag := flag.String("passphrase", "", "passphrase to protect or join gather points with")
 	flag.Parse()
 
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
@@ -33,10 +36,19 @@ func main() {
 	log.Info().Msg("Node initialized")
 
 	if *gatherFlag != 0 {
-		err := h.CreateGatherPoint(*gatherFlag, time.Second)
+		access := gather.Access{
+			Hidden:     *hiddenFlag,
+			Passphrase: *passphraseFlag,
+		}
+
+		err := h.CreateGatherPoint(*gatherFlag, time.Second, access, core.DefaultGameSettings())
 		if err != nil {
 			log.Err(err).Msg("New gather point")
 		}
+
+		if *hiddenFlag {
+			log.Info().Str("invite", h.Invite()).Msg("Hidden gather point created")
+		}
 	}
 
 	sigCh := make(chan os.Signal, 1)
@@ -52,12 +64,22 @@ func main() {
 				Msg("Game established")
 
 			gi := info.Game
+			gi.Start(info.Facilitator, info.Settings)
+			gi.Ready()
+
+			countdown, err := gi.ReadyCheck()
+			if err != nil {
+				log.Err(err).Msg("Ready check")
+				os.Exit(1)
+			}
+
 			seed := gi.Run()
+			time.Sleep(countdown)
 
 			log.Info().Int64("seed", seed).Msg("Got new seed")
 
 			for i := 0; i < 3; i++ {
-				err := gi.SendMove(core.Up)
+				_, err := gi.SendMove(core.Up)
 				if err != nil {
 					log.Err(err).Msg("Test move")
 				}
@@ -66,7 +88,7 @@ func main() {
 
 				e, ok := <-gi.IncommingMoves()
 				switch e := e.(type) {
-				case core.PlayerMoves:
+				case game.TickPlayed:
 					if !ok {
 						log.Info().Msg("GameInstance closed incoming moves")
 					}
@@ -79,8 +101,12 @@ func main() {
 							Int("dir", int(dir)).
 							Msg("Player moved")
 					}
-				case peer.ID:
-					log.Info().Str("player", e.Pretty()).Msg("Player disconnected")
+
+					for _, ev := range e.Events {
+						if d, died := ev.(core.PlayerDied); died {
+							log.Info().Str("player", d.SnakeID.Pretty()).Stringer("cause", d.Cause).Msg("Player died")
+						}
+					}
 				}
 			}
 
@@ -97,7 +123,7 @@ func main() {
 
 			gatherPoints[msg.ConnectTo.ID] = msg
 
-			err := h.JoinGatherPoint(ctx, msg.ConnectTo)
+			err := h.JoinGatherPoint(ctx, msg.ConnectTo, *passphraseFlag)
 			if err != nil {
 				log.Err(err).Msg("Join gather point")
 			}
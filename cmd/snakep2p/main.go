@@ -2,9 +2,12 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 
 	snake "github.com/kuredoro/snake_p2p"
+	"github.com/kuredoro/snake_p2p/core"
 	"github.com/kuredoro/snake_p2p/engine/console"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/context"
@@ -14,8 +17,35 @@ import (
 func main() {
 	// gatherFlag := flag.Int("gather", 0, "create gather point for N players")
 	logNameFlag := flag.String("logname", "ui_logs.txt", "Name of log file")
+	passphraseFlag := flag.String("passphrase", "", "Passphrase of the gather point to join")
+	mapFlag := flag.String("map", "", "Map file to offer when creating a gather point")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %s [flags]\n  %s [flags] join snake://<peer id>@<multiaddr>,...\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	var invite string
+	switch {
+	case flag.NArg() == 0:
+	case flag.NArg() == 2 && flag.Arg(0) == "join":
+		invite = flag.Arg(1)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *mapFlag != "" {
+		raw, err := os.ReadFile(*mapFlag)
+		if err == nil {
+			_, err = core.Maps.Add(filepath.Base(*mapFlag), raw)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load map: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	f, _ := os.Create(*logNameFlag)
 	log.Logger = log.Output(f)
 	ctx := context.Background()
@@ -28,6 +58,14 @@ func main() {
 	log.Info().Msg("Node initialized")
 
 	g := console.NewGatherUI(h)
+	if invite != "" {
+		err := g.JoinInvite(invite, *passphraseFlag)
+		if err != nil {
+			h.Close()
+			fmt.Fprintf(os.Stderr, "join: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	// Shortcuts to navigate the slides.
 	//console.App.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 	//	if event.Key() == tcell.KeyCtrlN {
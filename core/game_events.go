@@ -1,6 +1,11 @@
 package core
 
-import "github.com/libp2p/go-libp2p-core/peer"
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
 
 type Coord struct {
 	X, Y int
@@ -14,9 +19,37 @@ type PlayerStarts struct {
 	Players map[peer.ID]Coord // map from player's SnakeID to its start coordinates
 }
 
+// ItemKind is what a food item does to the snake that eats it.
+type ItemKind int
+
+const (
+	Food    ItemKind = iota // +1 segment
+	BigFood                 // +3 segments
+	Speed                   // two cells a tick for a while
+	Ghost                   // pass through the snakes for a while
+	Shrink                  // the rivals lose segments
+)
+
+func (k ItemKind) String() string {
+	switch k {
+	case Food:
+		return "food"
+	case BigFood:
+		return "big food"
+	case Speed:
+		return "speed boost"
+	case Ghost:
+		return "ghost"
+	case Shrink:
+		return "shrink"
+	}
+	return fmt.Sprintf("item %d", int(k))
+}
+
 type NewFood struct {
 	FoodID int
 	Pos    Coord
+	Kind   ItemKind
 }
 
 type Direction int
@@ -28,16 +61,92 @@ const (
 	Left
 )
 
+// Valid tells whether the direction is one of the four above.
+func (d Direction) Valid() bool {
+	return d >= Up && d <= Left
+}
+
+// Opposite returns the direction that reverses this one.
+func (d Direction) Opposite() Direction {
+	return (d + 2) % 4
+}
+
 type PlayerMoves struct {
+	Tick  int                   // number of the tick the moves belong to
 	Moves map[peer.ID]Direction // map from player's SnakeID to direction of it's move
 }
 
 type PlayerDied struct {
-	SnakeID peer.ID // SnakeID of player who died
+	SnakeID peer.ID    // SnakeID of player who died
+	Cause   DeathCause // what killed the snake
+	Killer  peer.ID    // the snake run into, empty if none
+	Tick    int        // the move the snake died on
+}
+
+// DeathCause is what killed the snake.
+type DeathCause int
+
+const (
+	HeadOn     DeathCause = iota // ran head-on into the killer
+	Body                         // ran into the killer's body
+	Self                         // ran into its own body
+	Wall                         // ran into a wall or an obstacle
+	OutOfZone                    // was left outside the zone
+	Timeout                      // evicted for missing the moves
+	Disconnect                   // evicted for not coming back
+	Evicted                      // evicted for another reason, such as cheating
+)
+
+func (c DeathCause) String() string {
+	switch c {
+	case HeadOn:
+		return "head-on"
+	case Body:
+		return "body"
+	case Self:
+		return "self"
+	case Wall:
+		return "wall"
+	case OutOfZone:
+		return "zone"
+	case Timeout:
+		return "timeout"
+	case Disconnect:
+		return "disconnect"
+	case Evicted:
+		return "evicted"
+	}
+	return fmt.Sprintf("cause %d", int(c))
+}
+
+// PlayerStats are what the player has done in the game.
+type PlayerStats struct {
+	SnakeID   peer.ID
+	Ticks     int         // ticks survived
+	MaxLength int         // the longest the snake has been
+	Eaten     int         // food items eaten
+	Kills     int         // rivals that ran into the snake
+	Death     *PlayerDied // nil if the snake is alive
 }
 
 type FoodEaten struct {
-	FoodID int
+	FoodID  int
+	SnakeID peer.ID
+	Kind    ItemKind
+}
+
+// PowerUp is raised when the snake eats an item whose effect lasts for
+// Ticks ticks, or is instant if Ticks is 0.
+type PowerUp struct {
+	SnakeID peer.ID
+	Kind    ItemKind
+	Ticks   int
+}
+
+// PowerDown is raised when the effect of the item wears off.
+type PowerDown struct {
+	SnakeID peer.ID
+	Kind    ItemKind
 }
 
 type PushSegment struct {
@@ -47,7 +156,38 @@ type PushSegment struct {
 
 type Tick struct{}
 
+// Equivocation is raised when the player is caught sending different
+// moves to different players.
+type Equivocation struct {
+	SnakeID peer.ID
+	Tick    int
+}
+
+// InvalidMove is raised when the player's move breaks the rules. The
+// snake keeps its direction instead.
+type InvalidMove struct {
+	SnakeID peer.ID
+	Tick    int
+	Reason  string
+}
+
+// Timing is how the players pace their moves. It is raised when the
+// facilitator adjusts it to the latency between the players.
+type Timing struct {
+	Delay    int           // how many of our moves may be unconfirmed at once
+	TickRate time.Duration // how often we move
+}
+
 type GameOver struct {
-	Successful bool // did game finish without errors or not
-	Winner     int  // SnakeID of winner player
+	Successful bool          // did game finish without errors or not
+	Scores     []PlayerScore // final lengths of the snakes, longest first
+	Stats      []PlayerStats // match statistics of the players
+	Team       int           // the winning team in the team mode, from 1
+}
+
+// PlayerScore is the length of the player's snake at the end of the game.
+type PlayerScore struct {
+	SnakeID peer.ID
+	Length  int
+	Alive   bool
 }
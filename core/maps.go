@@ -0,0 +1,181 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MaxMapSize bounds the map files, they travel over the gather stream.
+const MaxMapSize = 64 << 10
+
+// Map is a custom board. In its text format, every line is a row of the
+// board inside the borders, and every character is a cell:
+//
+//	.    empty
+//	#    wall
+//	S    spawn zone of everyone
+//	1-4  spawn zone of the team
+//	F    food zone, if there is none, the food appears anywhere
+//
+// The lines starting with ';' are comments. The coordinates are counted
+// from the top left cell inside the borders.
+type Map struct {
+	Width, Height int
+	Walls         []Coord
+	Spawns        []Coord
+	TeamSpawns    [MaxTeams][]Coord
+	Food          []Coord
+}
+
+// ParseMap reads the map in the text format.
+func ParseMap(raw []byte) (*Map, error) {
+	if len(raw) > MaxMapSize {
+		return nil, fmt.Errorf("map is larger than %d bytes", MaxMapSize)
+	}
+
+	m := &Map{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(line) == 0 || line[0] == ';' {
+			continue
+		}
+
+		if m.Height == 0 {
+			m.Width = len(line)
+		} else if len(line) != m.Width {
+			return nil, fmt.Errorf("row %d is %d cells long, not %d", m.Height+1, len(line), m.Width)
+		}
+
+		for x, cell := range line {
+			c := Coord{X: x, Y: m.Height}
+			switch {
+			case cell == '.':
+			case cell == '#':
+				m.Walls = append(m.Walls, c)
+			case cell == 'S':
+				m.Spawns = append(m.Spawns, c)
+			case cell >= '1' && cell < '1'+MaxTeams:
+				team := int(cell - '1')
+				m.TeamSpawns[team] = append(m.TeamSpawns[team], c)
+			case cell == 'F':
+				m.Food = append(m.Food, c)
+			default:
+				return nil, fmt.Errorf("unknown cell %q at (%d, %d)", cell, c.X, c.Y)
+			}
+		}
+		m.Height++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read map: %v", err)
+	}
+
+	if m.Width < 10 || m.Width > 200 || m.Height < 10 || m.Height > 100 {
+		return nil, fmt.Errorf("map size %dx%d is not within [10, 200]x[10, 100]", m.Width, m.Height)
+	}
+
+	return m, nil
+}
+
+// MapHash identifies the map by its content.
+func MapHash(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// MapInfo names a map of the store.
+type MapInfo struct {
+	Hash string
+	Name string
+	Map  *Map
+}
+
+// MapStore keeps the maps the node knows by their hashes: the ones loaded
+// by the user and the ones received from the facilitators.
+type MapStore struct {
+	mu   sync.Mutex
+	raw  map[string][]byte
+	maps map[string]MapInfo
+}
+
+func NewMapStore() *MapStore {
+	return &MapStore{
+		raw:  make(map[string][]byte),
+		maps: make(map[string]MapInfo),
+	}
+}
+
+// Maps are the maps of this node.
+var Maps = NewMapStore()
+
+// Add parses the map and keeps it, its hash is returned.
+func (s *MapStore) Add(name string, raw []byte) (string, error) {
+	m, err := ParseMap(raw)
+	if err != nil {
+		return "", err
+	}
+
+	hash := MapHash(raw)
+	if name == "" {
+		name = hash[:8]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.raw[hash] = append([]byte(nil), raw...)
+	s.maps[hash] = MapInfo{Hash: hash, Name: name, Map: m}
+
+	return hash, nil
+}
+
+// Get returns the map with the hash, if we have it.
+func (s *MapStore) Get(hash string) (*Map, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, exists := s.maps[hash]
+	return info.Map, exists
+}
+
+// Raw returns the map file with the hash, to be sent to the others.
+func (s *MapStore) Raw(hash string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, exists := s.raw[hash]
+	return raw, exists
+}
+
+// List returns the maps sorted by their names.
+func (s *MapStore) List() []MapInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]MapInfo, 0, len(s.maps))
+	for _, info := range s.maps {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+var errMapHash = errors.New("map hash is not a hex SHA-256")
+
+func validMapHash(hash string) error {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != sha256.Size {
+		return errMapHash
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GameSettings are chosen by the facilitator and are the same for all
+// the players of a game.
+type GameSettings struct {
+	Width, Height int           // size of the board inside the borders
+	FoodEvery     int           // new food is added every FoodEvery moves
+	TickRate      time.Duration // how often the snakes move
+
+	// Rollback makes the local moves apply right away. The other players
+	// are predicted to keep their direction, and the board is rewound
+	// when their real moves arrive.
+	Rollback bool
+
+	// Rounds makes the game a room: the players play a best-of-Rounds
+	// series, and the newcomers can join between the rounds.
+	Rounds int
+
+	// Teams splits the players into that many teams, the game goes on
+	// until one team is left. Zero is everyone for themselves.
+	Teams int
+	// TeamPass lets the teammates pass through each other.
+	TeamPass bool
+
+	// ShrinkEvery makes the playable zone shrink every that many moves,
+	// the snakes left outside die. Zero keeps the whole board.
+	ShrinkEvery int
+
+	// Walls are the edges of the board that kill the snakes touching
+	// them, the snakes wrap around the other edges.
+	Walls Edges
+
+	// SpeedRamp makes the ticks shorter as the game goes on, refer to
+	// TickRateAt.
+	SpeedRamp bool
+
+	// TimeLimit ends the game after that many moves, the longest snake
+	// wins. Zero plays until one is left.
+	TimeLimit int
+
+	// PowerUps makes some of the food items special, refer to ItemKind.
+	PowerUps bool
+
+	// Map is the content hash of the custom board, refer to Map. Width
+	// and Height are the map's. Empty is the open board.
+	Map string
+}
+
+// Edges is a set of the board's edges.
+type Edges int
+
+const (
+	TopEdge Edges = 1 << iota
+	RightEdge
+	BottomEdge
+	LeftEdge
+
+	AllEdges = TopEdge | RightEdge | BottomEdge | LeftEdge
+)
+
+func (e Edges) String() string {
+	switch e {
+	case 0:
+		return "wrap-around"
+	case AllEdges:
+		return "walls"
+	}
+
+	var names []string
+	for _, edge := range []struct {
+		Edges
+		name string
+	}{{TopEdge, "top"}, {RightEdge, "right"}, {BottomEdge, "bottom"}, {LeftEdge, "left"}} {
+		if e&edge.Edges != 0 {
+			names = append(names, edge.name)
+		}
+	}
+	return "walls on " + strings.Join(names, "/")
+}
+
+// MaxTeams is how many teams a game can have.
+const MaxTeams = 4
+
+const (
+	// MinTickRate is the shortest tick the speed ramp goes down to.
+	MinTickRate = 30 * time.Millisecond
+	// RampEvery is how many moves pass between the speed-ups.
+	RampEvery = 100
+)
+
+func DefaultGameSettings() GameSettings {
+	return GameSettings{
+		Width:     80,
+		Height:    40,
+		FoodEvery: 5,
+		TickRate:  100 * time.Millisecond,
+	}
+}
+
+func (s GameSettings) Validate() error {
+	if s.Width < 10 || s.Width > 200 {
+		return fmt.Errorf("board width %d is not within [10, 200]", s.Width)
+	}
+
+	if s.Height < 10 || s.Height > 100 {
+		return fmt.Errorf("board height %d is not within [10, 100]", s.Height)
+	}
+
+	if s.FoodEvery < 1 {
+		return fmt.Errorf("food interval %d is not positive", s.FoodEvery)
+	}
+
+	if s.TickRate < MinTickRate || s.TickRate > time.Second {
+		return fmt.Errorf("tick rate %v is not within [30ms, 1s]", s.TickRate)
+	}
+
+	if s.Rounds < 0 || s.Rounds > 9 {
+		return fmt.Errorf("number of rounds %d is not within [0, 9]", s.Rounds)
+	}
+
+	if s.Teams != 0 && (s.Teams < 2 || s.Teams > MaxTeams) {
+		return fmt.Errorf("number of teams %d is not 0 or within [2, %d]", s.Teams, MaxTeams)
+	}
+
+	if s.ShrinkEvery != 0 && (s.ShrinkEvery < 10 || s.ShrinkEvery > 1000) {
+		return fmt.Errorf("zone shrink interval %d is not 0 or within [10, 1000]", s.ShrinkEvery)
+	}
+
+	if s.TimeLimit != 0 && (s.TimeLimit < 100 || s.TimeLimit > 100000) {
+		return fmt.Errorf("time limit %d is not 0 or within [100, 100000]", s.TimeLimit)
+	}
+
+	if s.Walls&^AllEdges != 0 {
+		return fmt.Errorf("walls %d are not a set of the edges", s.Walls)
+	}
+
+	if s.Map != "" {
+		if err := validMapHash(s.Map); err != nil {
+			return err
+		}
+
+		m, known := Maps.Get(s.Map)
+		if known && (m.Width != s.Width || m.Height != s.Height) {
+			return fmt.Errorf("board %dx%d is not the size of the map", s.Width, s.Height)
+		}
+	}
+
+	return nil
+}
+
+func (s GameSettings) String() string {
+	str := fmt.Sprintf("%dx%d, %v, food every %d, %v", s.Width, s.Height, s.Walls, s.FoodEvery, s.TickRate)
+	if s.Rollback {
+		str += ", rollback"
+	}
+	if s.SpeedRamp {
+		str += ", speeding up"
+	}
+	if s.TimeLimit > 0 {
+		str += fmt.Sprintf(", %d moves", s.TimeLimit)
+	}
+	if s.Room() {
+		str += fmt.Sprintf(", best of %d", s.Rounds)
+	}
+	if s.Teams > 0 {
+		str += fmt.Sprintf(", %d teams", s.Teams)
+		if s.TeamPass {
+			str += " passing through each other"
+		}
+	}
+	if s.ShrinkEvery > 0 {
+		str += fmt.Sprintf(", zone shrinks every %d", s.ShrinkEvery)
+	}
+	if s.PowerUps {
+		str += ", power-ups"
+	}
+	if s.Map != "" {
+		str += ", map " + s.Map[:8]
+	}
+	return str
+}
+
+// TickRateAt is the tick at the move, given the rate the players have
+// agreed on. With SpeedRamp, it is a tenth shorter every RampEvery moves.
+func (s GameSettings) TickRateAt(rate time.Duration, move int) time.Duration {
+	if !s.SpeedRamp {
+		return rate
+	}
+
+	for i := 0; i < move/RampEvery && rate > MinTickRate; i++ {
+		rate -= rate / 10
+	}
+	if rate < MinTickRate {
+		rate = MinTickRate
+	}
+	return rate
+}
+
+// Room tells whether the players stay together for a series of rounds.
+func (s GameSettings) Room() bool {
+	return s.Rounds > 1
+}
@@ -0,0 +1,105 @@
+package console
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// chatLines is how many last messages the overlay shows.
+const chatLines = 5
+
+type chatOverlay struct {
+	self     peer.ID
+	open     bool
+	messages []chat.Message
+	input    []rune
+	unread   int
+}
+
+func (c *chatOverlay) add(msg chat.Message) {
+	c.messages = append(c.messages, msg)
+	if len(c.messages) > chatLines {
+		c.messages = c.messages[len(c.messages)-chatLines:]
+	}
+
+	if !c.open {
+		c.unread++
+	}
+}
+
+// notice shows a message from the game itself.
+func (c *chatOverlay) notice(text string) {
+	c.add(chat.Message{Scope: chat.Game, Text: text})
+}
+
+// handleKey processes a key press while the overlay is open. It returns
+// false if the key was not meant for the chat, like the arrows.
+func (c *chatOverlay) handleKey(ev *tcell.EventKey, send func(string) error) bool {
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		c.open = false
+	case tcell.KeyEnter:
+		text := string(c.input)
+		err := send(text)
+		if err != nil {
+			log.Err(err).Msg("Send game chat message")
+			c.add(chat.Message{Scope: chat.Game, From: c.self, Text: fmt.Sprintf("(not sent: %v)", err)})
+			return true
+		}
+
+		c.add(chat.Message{Scope: chat.Game, From: c.self, Text: text})
+		c.input = c.input[:0]
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(c.input) > 0 {
+			c.input = c.input[:len(c.input)-1]
+		}
+	case tcell.KeyRune:
+		if len(string(c.input))+len(string(ev.Rune())) <= chat.MaxLength {
+			c.input = append(c.input, ev.Rune())
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (c *chatOverlay) draw(s tcell.Screen, boundary Boundary, style tcell.Style) {
+	x1, x2 := boundary.TopLeft.X, boundary.BottomRight.X
+	y2 := boundary.BottomRight.Y
+
+	if !c.open {
+		hint := " t: chat "
+		if c.unread > 0 {
+			hint = fmt.Sprintf(" t: chat (%d new) ", c.unread)
+		}
+		drawText(s, x1+2, y2, x2, y2, style, hint)
+		return
+	}
+
+	y1 := y2 - chatLines - 3
+	drawBox(s, Boundary{core.Coord{X: x1, Y: y1}, core.Coord{X: x2, Y: y2}}, style)
+
+	row := y1 + 1
+	for _, msg := range c.messages {
+		from := "*"
+		switch msg.From {
+		case "":
+		case c.self:
+			from = "you"
+		default:
+			from = shortID(msg.From)
+		}
+
+		line := fmt.Sprintf("%s: %s", from, msg.Text)
+		drawText(s, x1+1, row, x2, row, style, line)
+		row++
+	}
+
+	drawText(s, x1+1, y2-1, x2, y2-1, style, "> "+string(c.input)+"_")
+}
@@ -1,11 +1,13 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"time"
 
+	"github.com/kuredoro/snake_p2p/engine/rules"
 	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/libp2p/go-libp2p-core/peer"
 
@@ -18,38 +20,72 @@ import (
 	//"github.com/sanity-io/litter"
 )
 
-type Snake struct {
-	Alive bool
-	Body  []core.Coord
-	Head  core.Coord
-	Style tcell.Style
+// maxPredict is how many of our moves can stay unconfirmed in the
+// rollback mode.
+const maxPredict = 8
+
+// maxQueued is how many key presses are buffered for the next ticks.
+const maxQueued = 3
+
+// input is our move that is not confirmed yet.
+type input struct {
+	tick int
+	dir  core.Direction
 }
 
 type GameUI struct {
-	gi          *game.GameInstance
-	Snakes      map[peer.ID]*Snake
-	Food        map[int]core.Coord
-	bound       Boundary
-	moveNum     int
-	AliveSnakes int
-	foodLastID  int
-	Over        bool
-	Successful  bool
-	WinnerID    peer.ID
-	r           *rand.Rand
-}
-
-// add food every N moves
-const N = 5
-
-func NewGame(gi *game.GameInstance) *GameUI {
+	gi       *game.GameInstance
+	settings core.GameSettings
+
+	// world has only the confirmed ticks. view is what is drawn, in the
+	// rollback mode it is ahead of world by our inputs.
+	world  *rules.World
+	view   *rules.World
+	inputs []input
+	queued []core.Direction
+
+	// stepped is when the world last moved, and moved is when the view
+	// did. The animation between the ticks follows the latter.
+	stepped time.Time
+	moved   time.Time
+	styles  map[peer.ID]tcell.Style
+
+	// timing is how often we move and how many of our moves may wait for
+	// the confirmation, it follows the latency between the players.
+	timing   core.Timing
+	netGraph bool
+
+	// standings are kept over the rounds of a room, they are nil outside
+	// of one. Once we are out, we watch the others finish the round.
+	standings rules.Standings
+	roundOver bool
+
+	bound      Boundary
+	Over       bool
+	Successful bool
+	WinnerID   peer.ID
+	WinnerTeam int
+	chat       chatOverlay
+}
+
+// NewGame creates the UI of a round. In a room, the standings are the game
+// instance's, they are the same for all the players.
+func NewGame(gi *game.GameInstance, settings core.GameSettings) *GameUI {
+	var standings rules.Standings
+	if settings.Room() {
+		standings = gi.Standings()
+	}
+
 	return &GameUI{
-		gi:         gi,
-		Food:       make(map[int]core.Coord),
-		Snakes:     make(map[peer.ID]*Snake),
-		moveNum:    0,
-		foodLastID: 0,
-		bound:      Boundary{core.Coord{X: 1, Y: 1}, core.Coord{X: 81, Y: 41}},
+		gi:        gi,
+		settings:  settings,
+		standings: standings,
+		styles:    make(map[peer.ID]tcell.Style),
+		netGraph:  true,
+		bound: Boundary{
+			TopLeft:     core.Coord{X: 1, Y: 1},
+			BottomRight: core.Coord{X: 1 + settings.Width, Y: 1 + settings.Height},
+		},
 		Over:       false,
 		Successful: false,
 	}
@@ -119,7 +155,7 @@ func drawBox(s tcell.Screen, boundary Boundary, style tcell.Style) {
 	// drawText(s, x1+1, y1+1, x2-1, y2-1, style, text)
 }
 
-func drawSnake(s tcell.Screen, ID peer.ID, snake *Snake, boundary Boundary, style tcell.Style) error {
+func drawSnake(s tcell.Screen, ID peer.ID, snake *rules.Snake, boundary Boundary, style tcell.Style) error {
 	if boundary.Contains(snake.Head) {
 		return fmt.Errorf("snakep2p's head coordinates (%d, %d) are out of boundary", snake.Head.X, snake.Head.Y)
 	}
@@ -134,11 +170,24 @@ func drawSnake(s tcell.Screen, ID peer.ID, snake *Snake, boundary Boundary, styl
 	return nil
 }
 
-func drawFood(s tcell.Screen, food core.Coord, style tcell.Style, boundary Boundary) error {
-	if boundary.Contains(food) {
+// itemLooks are the glyphs and the colors of the food items.
+var itemLooks = map[core.ItemKind]struct {
+	glyph rune
+	color tcell.Color
+}{
+	core.Food:    {'#', tcell.ColorGreen},
+	core.BigFood: {'@', tcell.ColorDarkGreen},
+	core.Speed:   {'»', tcell.ColorOrange},
+	core.Ghost:   {'○', tcell.ColorWhite},
+	core.Shrink:  {'×', tcell.ColorRed},
+}
+
+func drawFood(s tcell.Screen, food rules.Item, style tcell.Style, boundary Boundary) error {
+	if boundary.Contains(food.Pos) {
 		return fmt.Errorf("food coordinates are out of boundary")
 	}
-	s.SetContent(food.X, food.Y, '#', nil, style)
+	looks := itemLooks[food.Kind]
+	s.SetContent(food.Pos.X, food.Pos.Y, looks.glyph, nil, style.Foreground(looks.color))
 	return nil
 }
 
@@ -167,219 +216,137 @@ func genSnakeStyle(defColors *map[tcell.Color]struct{}) tcell.Style {
 	return style
 }
 
-func (g *GameUI) markDead(newHeadCoord map[peer.ID]core.Coord) {
-	// Check snakes for death
-	for id1, coord1 := range newHeadCoord {
-		// head into head
-		dead1 := false
-		for id2, coord2 := range newHeadCoord {
-			if id1.Pretty() == id2.Pretty() {
-				continue
-			}
-			if core.EqualCoord(coord1, coord2) {
-				dead1 = true
-				if g.gi.SelfID() == id2 {
-					g.Over = true
-					g.Successful = true
-					g.WinnerID = peer.ID("1")
-				}
-				g.Snakes[id2].Alive = false
-				g.gi.RemovePeer(id2)
-				g.AliveSnakes--
-			}
-		}
-		// head into body
-		for _, snake := range g.Snakes {
-			if !snake.Alive {
-				continue
-			}
-			if core.EqualCoord(snake.Head, coord1) {
-				dead1 = true
-				break
-			}
-			for _, b := range snake.Body {
-				if core.EqualCoord(b, coord1) {
-					dead1 = true
-					break
-				}
-			}
-		}
-		if dead1 {
-			if g.gi.SelfID() == id1 {
-				g.Over = true
-				g.Successful = true
-				g.WinnerID = peer.ID("1")
-			}
-			g.Snakes[id1].Alive = false
-			g.gi.RemovePeer(id1)
-			g.AliveSnakes--
-		}
-	}
+// teamColors are the color families of the teams, the teammates get the
+// shades of the same one.
+var teamColors = [core.MaxTeams][]tcell.Color{
+	{tcell.ColorRed, tcell.ColorSalmon, tcell.ColorMaroon, tcell.ColorCrimson},
+	{tcell.ColorBlue, tcell.ColorSkyblue, tcell.ColorNavy, tcell.ColorRoyalBlue},
+	{tcell.ColorGreen, tcell.ColorLime, tcell.ColorDarkGreen, tcell.ColorSeaGreen},
+	{tcell.ColorYellow, tcell.ColorKhaki, tcell.ColorOrange, tcell.ColorGold},
 }
 
-func (g *GameUI) goodCoord(coord core.Coord) core.Coord {
-	if coord.X == g.bound.TopLeft.X {
-		coord = core.Coord{X: g.bound.BottomRight.X - 1, Y: coord.Y}
-	}
-	if coord.X == g.bound.BottomRight.X {
-		coord = core.Coord{X: g.bound.TopLeft.X + 1, Y: coord.Y}
-	}
-	if coord.Y == g.bound.TopLeft.Y {
-		coord = core.Coord{X: coord.X, Y: g.bound.BottomRight.Y - 1}
-	}
-	if coord.Y == g.bound.BottomRight.Y {
-		coord = core.Coord{X: coord.X, Y: g.bound.TopLeft.Y + 1}
-	}
-	return coord
+// teamStyle is the style of the nth member of the team.
+func teamStyle(team, n int) tcell.Style {
+	shades := teamColors[(team-1)%len(teamColors)]
+	return tcell.StyleDefault.Foreground(shades[n%len(shades)]).Background(tcell.ColorBlack)
 }
 
-func (g *GameUI) handleOutOfBoundary(newHeadCoord *map[peer.ID]core.Coord) {
-	for id, coord := range *newHeadCoord {
-		(*newHeadCoord)[id] = g.goodCoord(coord)
+// step takes the world the game instance has played the confirmed tick
+// to.
+func (g *GameUI) step(played game.TickPlayed) {
+	g.stepped = time.Now()
+	if !g.settings.Rollback {
+		g.moved = g.stepped
 	}
-}
 
-func (g *GameUI) eatFood(newHeadCoord map[peer.ID]core.Coord) {
-	for id, coord := range newHeadCoord {
-		for foodID, foodCoord := range g.Food {
-			if !core.EqualCoord(coord, foodCoord) {
-				continue
-			}
-			// add segment to snake
-			g.Snakes[id].Body = append(g.Snakes[id].Body, core.Coord{})
-			// remove food from field
-			delete(g.Food, foodID)
-			log.Info().Msgf("Food on (%d, %d) eaten by %s", coord.X, coord.Y, id.Pretty())
+	g.world.Restore(played.World)
+	for _, e := range played.Events {
+		if d, ok := e.(core.PlayerDied); ok {
+			g.died(d.SnakeID)
 		}
 	}
-}
+	g.finish()
+	g.announce(played.Events)
 
-func (g *GameUI) moveSnakes(newHeadCoord map[peer.ID]core.Coord) {
-	for id, coord := range newHeadCoord {
-		if !g.Snakes[id].Alive {
-			continue
-		}
-		// move head
-		prevHead := g.Snakes[id].Head
-		g.Snakes[id].Head = coord
-		log.Info().Msgf("New coordinates (%d, %d) for snake %s", g.Snakes[id].Head.X, g.Snakes[id].Head.Y, id.Pretty())
-		// move snakes body
-		if len(g.Snakes[id].Body) == 0 {
-			continue
-		}
-		for i := len(g.Snakes[id].Body) - 1; i > 0; i-- {
-			g.Snakes[id].Body[i] = g.Snakes[id].Body[i-1]
+	confirmed := 0
+	for _, in := range g.inputs {
+		if in.tick <= played.Tick {
+			confirmed++
 		}
-		g.Snakes[id].Body[0] = prevHead
 	}
+	g.inputs = g.inputs[confirmed:]
+
+	g.predict()
 }
 
-func (g *GameUI) newFood() {
-	if g.moveNum%N != 0 {
-		return
-	}
+// tickRate is how often we move now, the speed ramp shortens the ticks
+// the players have agreed on.
+func (g *GameUI) tickRate() time.Duration {
+	return g.settings.TickRateAt(g.timing.TickRate, g.world.MoveNum)
+}
 
-	x1, y1 := g.bound.TopLeft.X, g.bound.TopLeft.Y
-	x2, y2 := g.bound.BottomRight.X, g.bound.BottomRight.Y
-	filled := len(g.Food)
-	for _, snake := range g.Snakes {
-		filled += len(snake.Body) + 1
-	}
-	cell := g.r.Intn((x2-x1-1)*(y2-y1-1) - filled)
-	for row := x1 + 1; row < x2; row++ {
-		for col := y1 + 1; col < y2; col++ {
-			flag := true
-			for _, snake := range g.Snakes {
-				if snake.Head.X == row && snake.Head.Y == col {
-					flag = false
-					break
-				}
-				for _, b := range snake.Body {
-					if b.X == row && b.Y == col {
-						flag = false
-						break
-					}
-				}
-			}
-			for _, f := range g.Food {
-				if f.X == row && f.Y == col {
-					flag = false
-					break
-				}
-			}
-			if flag {
-				cell--
-			}
-			if cell == 0 {
-				g.Food[g.foodLastID] = core.Coord{X: row, Y: col}
-				g.foodLastID++
-				log.Info().Msgf("New food should be created on (%d, %d)", row, col)
-				return
+// announce tells the chat about the deaths and the power-ups taken and
+// worn off.
+func (g *GameUI) announce(events []interface{}) {
+	for _, e := range events {
+		switch e := e.(type) {
+		case core.PlayerDied:
+			g.chat.notice(deathNotice(e))
+		case core.PowerUp:
+			if e.Ticks > 0 {
+				g.chat.notice(fmt.Sprintf("%s took %v for %d moves", shortID(e.SnakeID), e.Kind, e.Ticks))
+			} else {
+				g.chat.notice(fmt.Sprintf("%s took %v", shortID(e.SnakeID), e.Kind))
 			}
+		case core.PowerDown:
+			g.chat.notice(fmt.Sprintf("%s's %v wore off", shortID(e.SnakeID), e.Kind))
 		}
 	}
 }
 
-func (g *GameUI) isOver() bool {
-	if g.AliveSnakes == 1 {
+// died ends the game for us, if it was our snake.
+func (g *GameUI) died(id peer.ID) {
+	if id == g.gi.SelfID() {
 		g.Over = true
 		g.Successful = true
-		for id, snake := range g.Snakes {
-			if snake.Alive {
-				g.WinnerID = id
-				break
-			}
-		}
-	} else if g.AliveSnakes < 1 {
+		g.WinnerID = ""
+	}
+}
+
+// finish ends the game once the rules have ended it, be it the last rival
+// dying or the time running out with nobody dying.
+func (g *GameUI) finish() {
+	if g.world.Over && !g.roundOver {
+		result := g.world.Result()
 		g.Over = true
-		g.Successful = false
-	}
-	return g.Over
-}
-
-func (g *GameUI) handleMoves(moves core.PlayerMoves) {
-	newHeadCoord := make(map[peer.ID]core.Coord)
-	for id, dir := range moves.Moves {
-		switch dir {
-		case core.Up:
-			newHeadCoord[id] = core.Coord{X: g.Snakes[id].Head.X, Y: g.Snakes[id].Head.Y - 1}
-		case core.Left:
-			newHeadCoord[id] = core.Coord{X: g.Snakes[id].Head.X - 1, Y: g.Snakes[id].Head.Y}
-		case core.Right:
-			newHeadCoord[id] = core.Coord{X: g.Snakes[id].Head.X + 1, Y: g.Snakes[id].Head.Y}
-		case core.Down:
-			newHeadCoord[id] = core.Coord{X: g.Snakes[id].Head.X, Y: g.Snakes[id].Head.Y + 1}
-		default:
-			panic("the value of direction is unknown")
-		}
+		g.Successful = result.Successful
+		g.WinnerID = g.world.WinnerID
+		g.WinnerTeam = result.Team
+		g.endRound()
 	}
-	g.handleOutOfBoundary(&newHeadCoord)
-	g.markDead(newHeadCoord)
-	if g.isOver() {
+}
+
+// spectating tells whether we follow the game to its end after our snake
+// is out: in a room, and when our team plays on.
+func (g *GameUI) spectating() bool {
+	return g.standings != nil || g.world.Teams != nil
+}
+
+// endRound picks up the standings of the room with the finished round
+// counted in. The game instance is ahead of us, it has counted it already.
+func (g *GameUI) endRound() {
+	if g.roundOver {
 		return
 	}
-	g.eatFood(newHeadCoord)
-	g.moveSnakes(newHeadCoord)
-	g.newFood()
-	g.moveNum++
-	log.Info().Msgf("Next move %d", g.moveNum)
-}
+	g.roundOver = true
 
-func (g *GameUI) checkMove(move core.Coord) bool {
-	id := g.gi.SelfID()
-	if len(g.Snakes[id].Body) > 0 {
-		if g.Snakes[id].Body[0] == move {
-			return false
-		}
+	if g.standings != nil {
+		g.standings = g.gi.Standings()
 	}
-	return true
 }
 
-var shiftMap = map[core.Direction]core.Coord{
-	core.Left:  {X: -1, Y: 0},
-	core.Right: {X: 1, Y: 0},
-	core.Up:    {X: 0, Y: -1},
-	core.Down:  {X: 0, Y: 1},
+// predict rewinds the view to the confirmed world and replays our
+// unconfirmed moves on it. The other players are expected to keep their
+// direction.
+func (g *GameUI) predict() {
+	if !g.settings.Rollback {
+		return
+	}
+
+	g.view.Restore(g.world.Snapshot())
+
+	self := g.gi.SelfID()
+	for _, in := range g.inputs {
+		moves := make(map[peer.ID]core.Direction)
+		for id, snake := range g.view.Snakes {
+			if snake.Alive {
+				moves[id] = snake.Dir
+			}
+		}
+		moves[self] = in.dir
+
+		g.view.Step(moves)
+	}
 }
 
 var key2Dir = map[tcell.Key]core.Direction{
@@ -389,52 +356,140 @@ var key2Dir = map[tcell.Key]core.Direction{
 	tcell.KeyDown:  core.Down,
 }
 
-func (g *GameUI) handleMove(dir core.Direction) bool {
-	id := g.gi.SelfID()
+// heading is where our snake goes after the moves we have sent. It is
+// unknown before our first move.
+func (g *GameUI) heading() (core.Direction, bool) {
+	if n := len(g.inputs); n > 0 {
+		return g.inputs[n-1].dir, true
+	}
+
+	snake, exists := g.world.Snakes[g.gi.SelfID()]
+	if !exists || !snake.Moved {
+		return 0, false
+	}
+	return snake.Dir, true
+}
+
+// enqueue buffers the direction for the next ticks, so that quick turns
+// are not lost. The reversals and the repeats are dropped right away.
+func (g *GameUI) enqueue(dir core.Direction) {
+	last, known := g.heading()
+	if n := len(g.queued); n > 0 {
+		last, known = g.queued[n-1], true
+	}
+
+	if known && (dir == last || dir == last.Opposite()) {
+		return
+	}
+
+	if len(g.queued) >= maxQueued {
+		return
+	}
+
+	g.queued = append(g.queued, dir)
+}
+
+// nextMove takes the buffered direction for the tick, or keeps the
+// heading if there is none.
+func (g *GameUI) nextMove() (core.Direction, bool) {
+	if len(g.queued) == 0 {
+		return g.heading()
+	}
 
-	newPos := g.Snakes[id].Head
-	newPos.X += shiftMap[dir].X
-	newPos.Y += shiftMap[dir].Y
+	dir := g.queued[0]
+	g.queued = g.queued[1:]
+	return dir, true
+}
 
-	newPos = g.goodCoord(newPos)
-	if !g.checkMove(newPos) {
+func (g *GameUI) handleMove(dir core.Direction) bool {
+	snake, exists := g.view.Snakes[g.gi.SelfID()]
+	if !exists || !snake.Alive {
 		return false
 	}
 
-	err := g.gi.SendMove(dir)
+	tick, err := g.gi.SendMove(dir)
 	if err != nil {
 		log.Err(err).Int("move", int(dir)).Msg("Key pressed")
 		return false
 	}
 
-	log.Info().Int("move", int(core.Up)).Msg("Key pressed")
+	g.inputs = append(g.inputs, input{tick: tick, dir: dir})
+	g.predict()
+	if g.settings.Rollback {
+		g.moved = time.Now()
+	}
+
+	log.Info().Int("move", int(dir)).Int("tick", tick).Msg("Key pressed")
 	return true
 }
 
-func (g *GameUI) RunGame(seed int64) {
+// ahead is how many of our moves may be unconfirmed before we wait for
+// the others.
+func (g *GameUI) ahead() int {
+	if g.settings.Rollback && g.timing.Delay < maxPredict {
+		return maxPredict
+	}
+	return g.timing.Delay
+}
+
+func (g *GameUI) spawnSnakes(seed int64) {
 	rand.Seed(seed)
-	g.r = rand.New(rand.NewSource(seed))
-	// Generate snakes
+
+	teams := g.gi.Teams()
+	g.world = &rules.World{}
+	g.world.Restore(g.gi.World())
+	g.view = g.world
+	if g.settings.Rollback {
+		g.view = &rules.World{}
+		g.view.Restore(g.world.Snapshot())
+	}
+
+	// The colors taken are of this game, the defaults stay for the next.
+	taken := make(map[tcell.Color]struct{}, len(defColors))
+	for color := range defColors {
+		taken[color] = struct{}{}
+	}
+
+	members := make(map[int]int)
 	for _, id := range g.gi.PlayersIDs() {
-		var start core.Coord
-		for {
-			start = core.Coord{
-				X: g.r.Intn(g.bound.BottomRight.X-g.bound.TopLeft.X-1) + 1,
-				Y: g.r.Intn(g.bound.BottomRight.Y-g.bound.TopLeft.Y-1) + 1,
-			}
-			flag := true
-			for _, snake := range g.Snakes {
-				if core.EqualCoord(start, snake.Head) {
-					flag = false
-				}
-			}
-			if flag {
-				break
-			}
+		if team, ok := teams[id]; ok {
+			g.styles[id] = teamStyle(team, members[team])
+			members[team]++
+			continue
 		}
-		g.Snakes[id] = &Snake{Alive: true, Head: start, Style: genSnakeStyle(&defColors)}
+		g.styles[id] = genSnakeStyle(&taken)
+	}
+	g.chat.self = g.gi.SelfID()
+}
+
+// drawAway lists the players that are reconnecting on the top border.
+func (g *GameUI) drawAway(s tcell.Screen, style tcell.Style) {
+	away := g.gi.Away()
+	if len(away) == 0 || g.Over {
+		return
 	}
-	g.AliveSnakes = len(g.Snakes)
+
+	text := " reconnecting:"
+	for _, id := range away {
+		text += " " + shortID(id)
+	}
+	text += " "
+
+	x1, x2 := g.bound.TopLeft.X, g.bound.BottomRight.X
+	y := g.bound.TopLeft.Y
+	drawText(s, x1+2, y, x2, y, style, text)
+}
+
+// Outcome is what the player chose to do after the game.
+type Outcome int
+
+const (
+	Leave   Outcome = iota // back to the lobby
+	Rematch                // play again with the same players
+)
+
+// RunGame plays the game on its own screen until the player leaves.
+func (g *GameUI) RunGame(facilitator peer.ID) Outcome {
 	// Define GameUI styles
 	defStyle := tcell.StyleDefault.Background(tcell.ColorReset).Foreground(tcell.ColorReset)
 	boxStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorPurple)
@@ -460,37 +515,88 @@ func (g *GameUI) RunGame(seed int64) {
 		}
 	}()
 
+	// The screen goes back to the lobby once we return.
+	defer s.Fini()
+
 	eventCh := make(chan tcell.Event)
-	defer close(eventCh)
+	stop := make(chan struct{})
+	defer close(stop)
 	go func() {
 		for {
 			e := s.PollEvent()
 			if e == nil {
 				return
 			}
-			eventCh <- e
+
+			select {
+			case eventCh <- e:
+			case <-stop:
+				return
+			}
 		}
 	}()
 
-	// Define function to quit the GameUI
-	quit := func() {
-		s.Fini()
-		os.Exit(0)
+	if _, known := core.Maps.Get(g.settings.Map); g.settings.Map != "" && !known {
+		g.cancelled(s, eventCh, blackBoxStyle, errNoMap)
+		return Leave
+	}
+
+	g.gi.Start(facilitator, g.settings)
+
+	countdown, err := g.readyCheck(s, eventCh, blackBoxStyle)
+	if errors.Is(err, errLeft) {
+		return Leave
+	}
+	if err != nil {
+		log.Err(err).Msg("Ready check")
+		g.cancelled(s, eventCh, blackBoxStyle, err)
+		return Leave
+	}
+
+	startAt := time.Now().Add(countdown)
+	g.spawnSnakes(g.gi.Run())
+	if g.world.Teams != nil {
+		// We stand out among our teammates.
+		snakeStyle = g.styles[g.gi.SelfID()].Background(tcell.ColorSilver)
+	}
+	g.styles[g.gi.SelfID()] = snakeStyle
+
+	g.timing = g.gi.Timing()
+
+	firstKeyEvent, left := g.countdown(s, eventCh, startAt, func() {
+		drawBox(s, g.bound, boxStyle)
+		g.drawWalls(s, boxStyle)
+		g.drawObstacles(s, boxStyle)
+		for id, snake := range g.view.Snakes {
+			style := g.styles[id]
+			if g.gi.SelfID() == id {
+				style = snakeStyle
+			}
+			_ = drawSnake(s, id, snake, g.bound, style)
+		}
+	}, blackBoxStyle)
+	if left {
+		return Leave
 	}
 
-	const moveRate = 100 * time.Millisecond
+	if firstKeyEvent != nil {
+		g.enqueue(key2Dir[firstKeyEvent.Key()])
+	}
+
+	g.stepped = time.Now()
+	g.moved = g.stepped
 
-	var lastKeyEvent *tcell.EventKey
-	timer := time.NewTimer(moveRate)
+	timer := time.NewTimer(g.tickRate())
+	armed := true
 	dead := func(Successful bool, finished bool) {
 		drawBox(s, g.bound, boxStyle)
 		width, height := 0, 0
 		if Successful {
-			height = 4
-			width = 15
+			height = 6
+			width = 28
 		} else {
-			height = 2
-			width = 15
+			height = 4
+			width = 28
 		}
 		x1 := (g.bound.BottomRight.X - g.bound.TopLeft.X - width) / 2
 		y1 := (g.bound.BottomRight.Y - g.bound.TopLeft.Y - height) / 2
@@ -500,109 +606,211 @@ func (g *GameUI) RunGame(seed int64) {
 		drawText(s, x1+1, y1+1, x2-1, y2-1, blackBoxStyle, "Game Over")
 		if g.Successful {
 			text := ""
-			if finished && g.WinnerID == g.gi.SelfID() {
+			switch {
+			case g.WinnerTeam != 0 && g.WinnerTeam == g.world.Teams[g.gi.SelfID()]:
+				text = "Your team won :)"
+			case g.WinnerTeam != 0:
+				text = fmt.Sprintf("Team %d won :(", g.WinnerTeam)
+			case g.world.Teams != nil && !g.roundOver:
+				text = "Your team plays on"
+			case finished && g.WinnerID == g.gi.SelfID():
 				text = "You won :)"
-			} else {
+			case finished && g.WinnerID == "" && g.world.TimeUp():
+				text = "Time is up, it's a draw"
+			default:
 				text = "You lose :("
 			}
 			drawText(s, x1+1, y1+3, x2-1, y2-1, blackBoxStyle, text)
 		}
+		hint := "Enter: lobby, r: rematch"
+		if g.standings != nil {
+			hint = "Esc: leave the room"
+		}
+		drawText(s, x1+1, y2-1, x2-1, y2-1, blackBoxStyle, hint)
 		s.Show()
 	}
+	verified := false
+	frames := time.NewTicker(frameEvery)
+	defer frames.Stop()
+
+	// In a room, the next round starts after a break.
+	var nextRound <-chan time.Time
+
 	// GameUI loop
 	for {
-		// Draw GameUI state
-		if g.Over {
-			dead(g.Successful, true)
-		} else {
-			drawBox(s, g.bound, boxStyle)
-			for id, snake := range g.Snakes {
-				if !snake.Alive {
-					continue
-				}
-				var style tcell.Style
-				if g.gi.SelfID() == id {
-					style = snakeStyle
-				} else {
-					style = snake.Style
+		select {
+		case <-frames.C:
+			if g.roundOver && g.standings != nil && nextRound == nil && !g.standings.Decided(g.settings.Rounds) {
+				nextRound = time.After(RoundBreak)
+			}
+
+			// Draw GameUI state
+			if g.roundOver && g.standings != nil {
+				drawBox(s, g.bound, boxStyle)
+				g.drawStandings(s, blackBoxStyle)
+			} else if g.Over {
+				if !verified {
+					verified = true
+					moveLog := g.gi.Log()
+					err := moveLog.Verify()
+					if err != nil {
+						log.Err(err).Msg("Verify move log")
+					} else {
+						log.Info().Int("ticks", moveLog.Len()).Hex("head", moveLog.Head()).Msg("Move log verified")
+					}
 				}
+				dead(g.Successful, true)
+				g.drawStats(s, blackBoxStyle)
+			} else {
+				drawBox(s, g.bound, boxStyle)
+				g.drawWalls(s, boxStyle)
+				g.drawZone(s, boxStyle)
+				g.drawClock(s, boxStyle)
+				g.drawObstacles(s, boxStyle)
+				g.drawLean(s, boxStyle)
+				for id, snake := range g.view.Snakes {
+					if !snake.Alive {
+						continue
+					}
+					var style tcell.Style
+					if g.gi.SelfID() == id {
+						style = snakeStyle
+					} else {
+						style = g.styles[id]
+					}
+					if snake.Ghost > 0 {
+						style = style.Dim(true)
+					}
 
-				err := drawSnake(s, id, snake, g.bound, style)
-				if err != nil {
-					s.Fini()
-					log.Err(err)
-					os.Exit(0)
+					err := drawSnake(s, id, snake, g.bound, style)
+					if err != nil {
+						s.Fini()
+						log.Err(err)
+						os.Exit(0)
+					}
+					// log.Info().Msg("Drew snake")
 				}
-				// log.Info().Msg("Drew snake")
-			}
-			for _, f := range g.Food {
-				err := drawFood(s, f, foodStyle, g.bound)
-				if err != nil {
-					s.Fini()
-					fmt.Println(err)
-					log.Err(err)
-					os.Exit(0)
+				for _, f := range g.view.Food {
+					err := drawFood(s, f, foodStyle, g.bound)
+					if err != nil {
+						s.Fini()
+						fmt.Println(err)
+						log.Err(err)
+						os.Exit(0)
+					}
+					// log.Info().Msg("Drew food")
 				}
-				// log.Info().Msg("Drew food")
 			}
-		}
-		s.Show()
-
-		select {
+			g.drawAway(s, blackBoxStyle)
+			g.drawWaiting(s, blackBoxStyle)
+			g.drawNet(s, blackBoxStyle)
+			g.chat.draw(s, g.bound, blackBoxStyle)
+			s.Show()
 		case <-timer.C:
+			armed = false
 			if g.Over {
 				continue
 			}
-			if lastKeyEvent == nil {
-				timer.Reset(moveRate)
+			dir, ok := g.nextMove()
+			if !ok {
+				timer.Reset(g.tickRate())
+				armed = true
 				continue
 			}
 
-			dir := key2Dir[lastKeyEvent.Key()]
-
 			moved := g.handleMove(dir)
 
-			if !moved {
-				log.Warn().Msg("c")
-				timer.Reset(moveRate)
+			// We do not wait for the others to move, unless we are too
+			// far ahead.
+			if !moved || len(g.inputs) < g.ahead() {
+				timer.Reset(g.tickRate())
+				armed = true
 				continue
 			}
+		case msg := <-g.gi.Chat():
+			g.chat.add(msg)
 		case e, ok := <-g.gi.IncommingMoves():
-			if g.Over {
+			// Out of the game, we may still follow it to the end.
+			if g.roundOver || (g.Over && !g.spectating()) {
 				continue
 			}
 			switch e := e.(type) {
-			case core.PlayerMoves:
+			case game.TickPlayed:
 				if !ok {
-					quit()
+					return Leave
 				}
 				log.Info().Msgf("Incoming message %#v", e.Moves)
 
-				g.handleMoves(e)
-				timer.Reset(moveRate)
-			case peer.ID:
-				g.Snakes[e].Alive = false
-				g.gi.RemovePeer(e)
-				g.AliveSnakes--
+				g.step(e)
+				if !armed && len(g.inputs) < g.ahead() {
+					timer.Reset(g.tickRate())
+					armed = true
+				}
+			case core.Timing:
+				log.Info().
+					Int("delay", e.Delay).
+					Dur("tick_rate", e.TickRate).
+					Msg("Timing changed")
+
+				g.timing = e
+				if !armed && len(g.inputs) < g.ahead() {
+					timer.Reset(g.tickRate())
+					armed = true
+				}
+			case core.InvalidMove:
+				g.chat.notice(fmt.Sprintf("%s made an invalid move: %s", shortID(e.SnakeID), e.Reason))
+			case core.Equivocation:
+				g.chat.notice(fmt.Sprintf("%s sent different moves to different players", shortID(e.SnakeID)))
+			case core.GameOver:
+				log.Error().Msg("Game stopped, cut off from the other players")
+				g.Over = true
+				g.Successful = e.Successful
+				g.roundOver = true
 			}
+		case <-nextRound:
+			return Rematch
 		case ev := <-eventCh:
 			switch ev := ev.(type) {
 			case *tcell.EventResize:
 				s.Sync()
 			case *tcell.EventKey:
+				if g.chat.open && g.chat.handleKey(ev, g.gi.SendChat) {
+					continue
+				}
+				if ev.Key() == tcell.KeyRune && ev.Rune() == 'n' {
+					g.netGraph = !g.netGraph
+					continue
+				}
+				if ev.Key() == tcell.KeyRune && ev.Rune() == 't' {
+					g.chat.open = true
+					g.chat.unread = 0
+					continue
+				}
 				if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC {
-					quit()
+					return Leave
+				}
+				if g.Over && g.standings != nil {
+					if ev.Key() != tcell.KeyEnter || !g.roundOver {
+						continue
+					}
+					if g.standings.Decided(g.settings.Rounds) {
+						return Leave
+					}
+					return Rematch
 				}
 				if g.Over && ev.Key() == tcell.KeyEnter {
-					quit()
+					return Leave
+				}
+				if g.Over && ev.Key() == tcell.KeyRune && ev.Rune() == 'r' {
+					return Rematch
 				}
 
-				_, arrow := key2Dir[ev.Key()]
+				dir, arrow := key2Dir[ev.Key()]
 				if !arrow {
 					continue
 				}
 
-				lastKeyEvent = ev
+				g.enqueue(dir)
 			}
 		}
 	}
@@ -1,14 +1,19 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
 	snake "github.com/kuredoro/snake_p2p"
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
 	"github.com/kuredoro/snake_p2p/protocol/gather"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/rivo/tview"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/net/context"
@@ -17,15 +22,37 @@ import (
 type GatherUI struct {
 	h             *snake.Node
 	app           *tview.Application
+	root          *tview.Flex
 	flex          *tview.Flex
 	myGatherPoint *tview.TextView
 	gameList      *tview.Table
 	createBtn     *tview.Button
-	newGame       *tview.InputField
+	newGame       *tview.Form
+	passphrase    *tview.InputField
+	invite        *tview.InputField
+	chatLog       *tview.TextView
+	chatInput     *tview.InputField
 	maxPlayers    int
 	gatherPoints  map[string]*gather.GatherPointMessage
 }
 
+// borders are the choices of the edges that are walls, borderNames
+// label them.
+var (
+	borders = []core.Edges{
+		0,
+		core.AllEdges,
+		core.TopEdge | core.BottomEdge,
+		core.LeftEdge | core.RightEdge,
+	}
+	borderNames = []string{
+		"wrap around",
+		"solid walls",
+		"walls on top and bottom",
+		"walls on left and right",
+	}
+)
+
 func addRow(table *tview.Table, msg *gather.GatherPointMessage, row int, color tcell.Color) {
 	ID := msg.ConnectTo.ID.Pretty()
 	tableCell := tview.NewTableCell(ID).
@@ -34,6 +61,10 @@ func addRow(table *tview.Table, msg *gather.GatherPointMessage, row int, color t
 		SetExpansion(1)
 	table.SetCell(row, 0, tableCell)
 	maxPlayers := strconv.Itoa(int(msg.DesiredPlayerCount))
+	if msg.DesiredPlayerCount == 0 {
+		// Joined by invite, the gather point was never announced
+		maxPlayers = "?"
+	}
 	tableCell = tview.NewTableCell(maxPlayers).
 		SetTextColor(color).
 		SetAlign(tview.AlignCenter).
@@ -44,6 +75,27 @@ func addRow(table *tview.Table, msg *gather.GatherPointMessage, row int, color t
 		SetAlign(tview.AlignCenter).
 		SetExpansion(1)
 	table.SetCell(row, 2, tableCell)
+	protected := ""
+	if msg.Protected {
+		protected = "yes"
+	}
+	tableCell = tview.NewTableCell(protected).
+		SetTextColor(color).
+		SetAlign(tview.AlignCenter).
+		SetExpansion(1)
+	table.SetCell(row, 3, tableCell)
+	settings := ""
+	if msg.DesiredPlayerCount != 0 {
+		settings = msg.Settings.String()
+	}
+	if msg.Room {
+		settings = "room in play: " + settings
+	}
+	tableCell = tview.NewTableCell(settings).
+		SetTextColor(color).
+		SetAlign(tview.AlignCenter).
+		SetExpansion(1)
+	table.SetCell(row, 4, tableCell)
 }
 
 func NewGatherUI(h *snake.Node) *GatherUI {
@@ -77,6 +129,16 @@ func NewGatherUI(h *snake.Node) *GatherUI {
 		SetAlign(tview.AlignCenter).
 		SetExpansion(1)
 	table.SetCell(1, 2, tableCell)
+	tableCell = tview.NewTableCell("Passphrase").
+		SetTextColor(tcell.ColorYellow).
+		SetAlign(tview.AlignCenter).
+		SetExpansion(1)
+	table.SetCell(1, 3, tableCell)
+	tableCell = tview.NewTableCell("Settings").
+		SetTextColor(tcell.ColorYellow).
+		SetAlign(tview.AlignCenter).
+		SetExpansion(1)
+	table.SetCell(1, 4, tableCell)
 	g.gameList = table
 	g.gameList.SetSelectedFunc(func(row, column int) {
 		if row == 1 {
@@ -84,52 +146,271 @@ func NewGatherUI(h *snake.Node) *GatherUI {
 		}
 		ID := g.gameList.GetCell(row, 0).Text
 		msg := g.gatherPoints[ID]
-		ctx := context.Background()
-		err := g.h.JoinGatherPoint(ctx, msg.ConnectTo)
-		if err != nil {
-			log.Err(err).Msg("Join gather point")
+		if !msg.Protected {
+			g.join(row, msg, "")
+			return
 		}
-		// cell := table.GetCell(row, 2)
-		// cell.Text = "✔️"
-		g.gameList.GetCell(row, 2).SetText("〇")
+
+		g.askPassphrase(row, msg)
 	})
 
-	g.newGame = tview.NewInputField().
-		SetLabel("Enter the maximum number of players ").
+	g.passphrase = tview.NewInputField().
+		SetLabel("Passphrase ").
 		SetFieldWidth(0).
 		SetFieldBackgroundColor(tcell.ColorBlack).
-		SetAcceptanceFunc(tview.InputFieldInteger)
+		SetMaskCharacter('*')
+
+	g.invite = tview.NewInputField().
+		SetLabel("Join by invite ").
+		SetPlaceholder(gather.InviteScheme + "...").
+		SetFieldWidth(0).
+		SetFieldBackgroundColor(tcell.ColorBlack)
 
-	g.newGame.SetDoneFunc(func(key tcell.Key) {
+	g.invite.SetDoneFunc(func(key tcell.Key) {
 		if key != tcell.KeyEnter {
-			// we don't want to do anything if they just tabbed away
 			return
 		}
-		g.maxPlayers, _ = strconv.Atoi(g.newGame.GetText())
-		err := g.h.CreateGatherPoint(g.maxPlayers, time.Second)
+
+		err := g.JoinInvite(g.invite.GetText(), "")
 		if err != nil {
-			log.Err(err).Msg("New gather point")
+			log.Err(err).Msg("Join by invite")
+			g.invite.SetLabel("Join by invite (failed) ")
+			return
 		}
+
+		g.invite.SetLabel("Join by invite ")
+		g.invite.SetText("")
+	})
+
+	defaults := core.DefaultGameSettings()
+	maps := core.Maps.List()
+	mapNames := []string{"open board"}
+	for _, info := range maps {
+		mapNames = append(mapNames, fmt.Sprintf("%s (%dx%d)", info.Name, info.Map.Width, info.Map.Height))
+	}
+
+	g.newGame = tview.NewForm().
+		AddInputField("Maximum number of players", "", 0, tview.InputFieldInteger, nil).
+		AddPasswordField("Passphrase (optional)", "", 0, '*', nil).
+		AddCheckbox("Hidden (join by invite only)", false, nil).
+		AddInputField("Board width", strconv.Itoa(defaults.Width), 0, tview.InputFieldInteger, nil).
+		AddInputField("Board height", strconv.Itoa(defaults.Height), 0, tview.InputFieldInteger, nil).
+		AddInputField("Food every N moves", strconv.Itoa(defaults.FoodEvery), 0, tview.InputFieldInteger, nil).
+		AddInputField("Tick rate, ms", strconv.Itoa(int(defaults.TickRate/time.Millisecond)), 0, tview.InputFieldInteger, nil).
+		AddCheckbox("Rollback (predict the other players)", defaults.Rollback, nil).
+		AddInputField("Rounds (best of, 1 for a single game)", "1", 0, tview.InputFieldInteger, nil).
+		AddInputField("Teams (0 for everyone for themselves)", "0", 0, tview.InputFieldInteger, nil).
+		AddCheckbox("Teammates pass through each other", false, nil).
+		AddInputField("Zone shrinks every N moves (0 for never)", "0", 0, tview.InputFieldInteger, nil).
+		AddDropDown("Borders", borderNames, 0, nil).
+		AddCheckbox("Power-ups", false, nil).
+		AddCheckbox("Speed ramp (ticks get shorter)", false, nil).
+		AddInputField("Time limit, moves (0 for none)", "0", 0, tview.InputFieldInteger, nil).
+		AddDropDown("Map", mapNames, 0, nil)
+
+	g.newGame.AddButton("Create", func() {
+		intField := func(label string) int {
+			n, _ := strconv.Atoi(g.newGame.GetFormItemByLabel(label).(*tview.InputField).GetText())
+			return n
+		}
+		passphraseField := g.newGame.GetFormItemByLabel("Passphrase (optional)").(*tview.InputField)
+		hiddenBox := g.newGame.GetFormItemByLabel("Hidden (join by invite only)").(*tview.Checkbox)
+		rollbackBox := g.newGame.GetFormItemByLabel("Rollback (predict the other players)").(*tview.Checkbox)
+		teamPassBox := g.newGame.GetFormItemByLabel("Teammates pass through each other").(*tview.Checkbox)
+		powerUpsBox := g.newGame.GetFormItemByLabel("Power-ups").(*tview.Checkbox)
+		rampBox := g.newGame.GetFormItemByLabel("Speed ramp (ticks get shorter)").(*tview.Checkbox)
+		border, _ := g.newGame.GetFormItemByLabel("Borders").(*tview.DropDown).GetCurrentOption()
+		chosenMap, _ := g.newGame.GetFormItemByLabel("Map").(*tview.DropDown).GetCurrentOption()
+
+		g.maxPlayers = intField("Maximum number of players")
+		access := gather.Access{
+			Hidden:     hiddenBox.IsChecked(),
+			Passphrase: passphraseField.GetText(),
+		}
+		settings := core.GameSettings{
+			Width:     intField("Board width"),
+			Height:    intField("Board height"),
+			FoodEvery: intField("Food every N moves"),
+			TickRate:  time.Duration(intField("Tick rate, ms")) * time.Millisecond,
+			Rollback:  rollbackBox.IsChecked(),
+			Rounds:    intField("Rounds (best of, 1 for a single game)"),
+			Teams:     intField("Teams (0 for everyone for themselves)"),
+			TeamPass:  teamPassBox.IsChecked(),
+
+			ShrinkEvery: intField("Zone shrinks every N moves (0 for never)"),
+			Walls:       borders[border],
+			PowerUps:    powerUpsBox.IsChecked(),
+			SpeedRamp:   rampBox.IsChecked(),
+			TimeLimit:   intField("Time limit, moves (0 for none)"),
+		}
+		if chosenMap > 0 {
+			// The map decides the size of the board.
+			info := maps[chosenMap-1]
+			settings.Map = info.Hash
+			settings.Width = info.Map.Width
+			settings.Height = info.Map.Height
+		}
+
 		g.myGatherPoint.Clear()
-		fmt.Fprintf(g.myGatherPoint, "Max # of players: %d", g.maxPlayers)
+		err := g.h.CreateGatherPoint(g.maxPlayers, time.Second, access, settings)
+		if err != nil {
+			log.Err(err).Msg("New gather point")
+			fmt.Fprintf(g.myGatherPoint, "[red]%s[-]", tview.Escape(err.Error()))
+			return
+		}
+		fmt.Fprintf(g.myGatherPoint, "Max # of players: %d, %v", g.maxPlayers, settings)
+		if access.Passphrase != "" {
+			fmt.Fprintf(g.myGatherPoint, ", protected by passphrase")
+		}
+		if access.Hidden {
+			fmt.Fprintf(g.myGatherPoint, ", hidden")
+		}
+		fmt.Fprintf(g.myGatherPoint, "\nInvite link:\n%s", g.h.Invite())
+		log.Info().Str("invite", g.h.Invite()).Msg("Gather point invite")
 		g.flex.RemoveItem(g.newGame)
+		g.app.SetFocus(g.gameList)
 	})
 
 	g.createBtn = tview.NewButton("Create gather point").SetSelectedFunc(func() {
 		g.flex.RemoveItem(g.createBtn)
-		g.flex.AddItem(g.newGame, 0, 1, false)
+		g.flex.AddItem(g.newGame, 0, 2, false)
+		g.app.SetFocus(g.newGame)
 	})
 
 	g.flex = tview.NewFlex().
 		SetDirection(tview.FlexRow).
-		AddItem(g.myGatherPoint, 3, 1, false).
+		AddItem(g.myGatherPoint, 6, 1, false).
 		AddItem(g.gameList, 0, 3, false).
+		AddItem(g.invite, 1, 1, false).
 		AddItem(g.createBtn, 2, 1, false)
 
-	g.app.SetRoot(g.flex, true).EnableMouse(true)
+	g.chatLog = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWordWrap(true).
+		SetScrollable(true).
+		SetChangedFunc(func() { g.app.Draw() })
+	g.chatLog.SetBorder(true).SetTitle("Chat")
+
+	g.chatInput = tview.NewInputField().
+		SetLabel("> ").
+		SetPlaceholder("/g to talk in the gather point").
+		SetFieldWidth(0).
+		SetFieldBackgroundColor(tcell.ColorBlack)
+
+	g.chatInput.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter {
+			return
+		}
+
+		text := g.chatInput.GetText()
+		var err error
+		if strings.HasPrefix(text, "/g ") {
+			err = g.h.SendGatherChat(strings.TrimPrefix(text, "/g "))
+		} else {
+			err = g.h.SendLobbyChat(context.Background(), text)
+		}
+
+		if err != nil {
+			log.Err(err).Msg("Send chat message")
+			fmt.Fprintf(g.chatLog, "[red]%s[-]\n", tview.Escape(err.Error()))
+			return
+		}
+
+		g.chatInput.SetText("")
+	})
+
+	chatPane := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(g.chatLog, 0, 1, false).
+		AddItem(g.chatInput, 1, 1, false)
+
+	g.root = tview.NewFlex().
+		AddItem(g.flex, 0, 2, false).
+		AddItem(chatPane, 0, 1, false)
+
+	g.app.SetRoot(g.root, true).EnableMouse(true)
 	return g
 }
 
+func shortID(id peer.ID) string {
+	str := id.Pretty()
+	return str[len(str)-6:]
+}
+
+func (g *GatherUI) showChat(msg chat.Message) {
+	from := shortID(msg.From)
+	if msg.From == g.h.ID() {
+		from = "you"
+	}
+
+	fmt.Fprintf(g.chatLog, "[gray]%s[-] [yellow]%s[-]: %s\n",
+		tview.Escape("["+msg.Scope.String()+"]"), from, tview.Escape(msg.Text))
+	g.chatLog.ScrollToEnd()
+}
+
+// JoinInvite joins the gather point the invite link points to. The
+// gather point is added to the list, even if it has never been announced.
+// If the passphrase is wrong, the user is asked to enter it.
+func (g *GatherUI) JoinInvite(invite, passphrase string) error {
+	pi, err := gather.ParseInvite(invite)
+	if err != nil {
+		return fmt.Errorf("parse invite: %v", err)
+	}
+
+	msg, exists := g.gatherPoints[pi.ID.Pretty()]
+	if !exists {
+		msg = &gather.GatherPointMessage{ConnectTo: pi}
+		g.gatherPoints[pi.ID.Pretty()] = msg
+		addRow(g.gameList, msg, len(g.gatherPoints)+1, tcell.ColorWhite)
+	}
+
+	for row := 2; row < g.gameList.GetRowCount(); row++ {
+		if g.gameList.GetCell(row, 0).Text == pi.ID.Pretty() {
+			return g.join(row, msg, passphrase)
+		}
+	}
+
+	return nil
+}
+
+func (g *GatherUI) askPassphrase(row int, msg *gather.GatherPointMessage) {
+	g.passphrase.SetText("")
+	g.passphrase.SetDoneFunc(func(key tcell.Key) {
+		g.flex.RemoveItem(g.passphrase)
+		g.app.SetFocus(g.gameList)
+		if key != tcell.KeyEnter {
+			return
+		}
+		g.join(row, msg, g.passphrase.GetText())
+	})
+	g.flex.AddItem(g.passphrase, 1, 1, false)
+	g.app.SetFocus(g.passphrase)
+}
+
+// join joins the gather point in the row and marks how it went. The
+// error is nil if the user is asked for the passphrase instead.
+func (g *GatherUI) join(row int, msg *gather.GatherPointMessage, passphrase string) error {
+	ctx := context.Background()
+	err := g.h.JoinGatherPoint(ctx, msg.ConnectTo, passphrase)
+	if err != nil {
+		log.Err(err).Msg("Join gather point")
+		if errors.Is(err, gather.ErrAccessDenied) {
+			if passphrase == "" {
+				g.askPassphrase(row, msg)
+				return nil
+			}
+			g.gameList.GetCell(row, 2).SetText("wrong passphrase")
+			return err
+		}
+		g.gameList.GetCell(row, 2).SetText("failed")
+		return err
+	}
+	// cell := table.GetCell(row, 2)
+	// cell.Text = "✔️"
+	g.gameList.GetCell(row, 2).SetText("〇")
+	return nil
+}
+
 func (g *GatherUI) eventLoop() {
 	sigCh := make(chan os.Signal, 1)
 	for {
@@ -140,12 +421,13 @@ func (g *GatherUI) eventLoop() {
 				Int("peer_count", info.Game.PeerCount()).
 				Msg("GameUI established")
 			gi := info.Game
-			game := NewGame(gi)
 			g.app.Suspend(func() {
-				seed := gi.Run()
-				game.RunGame(seed)
-				gi.Close()
+				for NewGame(gi, info.Settings).RunGame(info.Facilitator) == Rematch {
+					gi.Rematch()
+				}
+				g.h.EndGame(info.ID)
 			})
+			g.app.QueueUpdateDraw(g.backToLobby)
 			//for i := 0; i < 3; i++ {
 			//	err := gi.SendMove(core.Up)
 			//	if err != nil {
@@ -163,7 +445,15 @@ func (g *GatherUI) eventLoop() {
 			//	}
 			//}
 		case msg := <-g.h.GatherPoints:
-			if _, exists := g.gatherPoints[msg.ConnectTo.ID.Pretty()]; exists {
+			if known, exists := g.gatherPoints[msg.ConnectTo.ID.Pretty()]; exists {
+				// The gather point of a room stays open between the
+				// rounds, and the one joined by invite is only known
+				// from its beacon.
+				if !sameRow(known, msg) {
+					*known = *msg
+					g.updateRow(msg)
+					g.app.Draw()
+				}
 				continue
 			}
 
@@ -176,6 +466,15 @@ func (g *GatherUI) eventLoop() {
 			// Add cell to gather points table
 			addRow(g.gameList, msg, len(g.gatherPoints)+1, tcell.ColorWhite)
 			g.app.Draw()
+		case failed := <-g.h.FailedJoins:
+			log.Err(failed.Err).
+				Str("facilitator", failed.Facilitator.Pretty()).
+				Msg("Join gather point")
+			g.app.QueueUpdateDraw(func() {
+				g.markRow(failed.Facilitator, "failed")
+			})
+		case msg := <-g.h.Chat:
+			g.showChat(msg)
 		case <-sigCh:
 			g.h.Close()
 			return
@@ -183,6 +482,52 @@ func (g *GatherUI) eventLoop() {
 	}
 }
 
+// sameRow tells whether the beacons show the same in the gather point's row.
+func sameRow(a, b *gather.GatherPointMessage) bool {
+	return a.DesiredPlayerCount == b.DesiredPlayerCount &&
+		a.Protected == b.Protected &&
+		a.Room == b.Room &&
+		a.Settings == b.Settings
+}
+
+// updateRow redraws the row of the gather point that has changed.
+func (g *GatherUI) updateRow(msg *gather.GatherPointMessage) {
+	for row := 2; row < g.gameList.GetRowCount(); row++ {
+		if g.gameList.GetCell(row, 0).Text == msg.ConnectTo.ID.Pretty() {
+			joined := g.gameList.GetCell(row, 2).Text
+			addRow(g.gameList, msg, row, tcell.ColorWhite)
+			g.gameList.GetCell(row, 2).SetText(joined)
+			return
+		}
+	}
+}
+
+// markRow shows how joining the gather point went in its row.
+func (g *GatherUI) markRow(facilitator peer.ID, text string) {
+	for row := 2; row < g.gameList.GetRowCount(); row++ {
+		if g.gameList.GetCell(row, 0).Text == facilitator.Pretty() {
+			g.gameList.GetCell(row, 2).SetText(text)
+			return
+		}
+	}
+}
+
+// backToLobby clears what is left of the gathering of the finished game,
+// so that the player can gather or join another one.
+func (g *GatherUI) backToLobby() {
+	g.myGatherPoint.Clear()
+	fmt.Fprintf(g.myGatherPoint, "No gather point created.")
+
+	for row := 2; row < g.gameList.GetRowCount(); row++ {
+		g.gameList.GetCell(row, 2).SetText("")
+	}
+
+	g.flex.RemoveItem(g.newGame)
+	g.flex.RemoveItem(g.createBtn)
+	g.flex.AddItem(g.createBtn, 2, 1, false)
+	g.app.SetFocus(g.gameList)
+}
+
 func (g *GatherUI) Run() error {
 	go g.eventLoop()
 	return g.app.Run()
@@ -0,0 +1,55 @@
+package console
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// sparks are the bars of the net graph, from the lowest to the highest.
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// drawNet shows the latency on the top border: the graph of our worst
+// round-trip time, the worst one between any two players and the timing
+// picked for it.
+func (g *GameUI) drawNet(s tcell.Screen, style tcell.Style) {
+	if !g.netGraph || g.Over {
+		return
+	}
+
+	stats := g.gi.NetStats()
+
+	var top, last time.Duration
+	for _, d := range stats.History {
+		if d > top {
+			top = d
+		}
+		last = d
+	}
+
+	graph := make([]rune, 0, len(stats.History))
+	for _, d := range stats.History {
+		i := 0
+		if top > 0 {
+			i = int(d * time.Duration(len(sparks)-1) / top)
+		}
+		graph = append(graph, sparks[i])
+	}
+
+	text := fmt.Sprintf(" %s rtt %v, worst %v, delay %d, tick %v ",
+		string(graph),
+		last.Round(time.Millisecond),
+		stats.Worst.Round(time.Millisecond),
+		stats.Timing.Delay,
+		stats.Timing.TickRate.Round(time.Millisecond))
+
+	x2 := g.bound.BottomRight.X
+	x1 := x2 - utf8.RuneCountInString(text) - 1
+	if x1 < g.bound.TopLeft.X {
+		x1 = g.bound.TopLeft.X
+	}
+	y := g.bound.TopLeft.Y
+	drawText(s, x1, y, x2, y, style, text)
+}
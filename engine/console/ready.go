@@ -0,0 +1,149 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/game"
+)
+
+// drawPopup draws a box in the middle of the board with a line of text
+// on every other row.
+func drawPopup(s tcell.Screen, boundary Boundary, style tcell.Style, lines ...string) {
+	width := 0
+	for _, line := range lines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	width += 4
+	height := 2 * len(lines)
+
+	cx := (boundary.TopLeft.X + boundary.BottomRight.X) / 2
+	cy := (boundary.TopLeft.Y + boundary.BottomRight.Y) / 2
+	x1, y1 := cx-width/2, cy-height/2
+	x2, y2 := x1+width, y1+height
+
+	drawBox(s, Boundary{core.Coord{X: x1, Y: y1}, core.Coord{X: x2, Y: y2}}, style)
+	for i, line := range lines {
+		drawText(s, x1+2, y1+1+2*i, x2-1, y1+1+2*i, style, line)
+	}
+}
+
+var (
+	// errLeft is returned when the player leaves instead of getting ready.
+	errLeft = errors.New("left the game")
+
+	// errNoMap is shown when the map of the game has not reached us.
+	errNoMap = errors.New("the map of the game is missing")
+)
+
+type readyResult struct {
+	countdown time.Duration
+	err       error
+}
+
+// readyCheck asks the player to confirm they are ready and waits for the
+// others to do the same.
+func (g *GameUI) readyCheck(s tcell.Screen, events <-chan tcell.Event, style tcell.Style) (time.Duration, error) {
+	resCh := make(chan readyResult, 1)
+	go func() {
+		countdown, err := g.gi.ReadyCheck()
+		resCh <- readyResult{countdown, err}
+	}()
+
+	deadline := time.Now().Add(game.ReadyTimeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	ready := false
+	for {
+		s.Clear()
+		if ready {
+			drawPopup(s, g.bound, style, "Game found!", "Waiting for the other players...")
+		} else {
+			left := time.Until(deadline).Round(time.Second)
+			if left < 0 {
+				left = 0
+			}
+			drawPopup(s, g.bound, style, "Game found!", fmt.Sprintf("Press Enter when ready (%v)", left))
+		}
+		s.Show()
+
+		select {
+		case res := <-resCh:
+			return res.countdown, res.err
+		case <-ticker.C:
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case *tcell.EventResize:
+				s.Sync()
+			case *tcell.EventKey:
+				switch ev.Key() {
+				case tcell.KeyEscape, tcell.KeyCtrlC:
+					return 0, errLeft
+				case tcell.KeyEnter:
+					if !ready {
+						g.gi.Ready()
+						ready = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// cancelled tells the player that the game will not take place.
+func (g *GameUI) cancelled(s tcell.Screen, events <-chan tcell.Event, style tcell.Style, err error) {
+	s.Clear()
+	drawPopup(s, g.bound, style, "Game cancelled", err.Error(), "Press any key")
+	s.Show()
+
+	for ev := range events {
+		switch ev.(type) {
+		case *tcell.EventResize:
+			s.Sync()
+		case *tcell.EventKey:
+			return
+		}
+	}
+}
+
+// countdown shows the board until the game starts. The arrow key pressed
+// last during the countdown becomes the first move. The player can also
+// leave.
+func (g *GameUI) countdown(s tcell.Screen, events <-chan tcell.Event, startAt time.Time, drawBoard func(), style tcell.Style) (*tcell.EventKey, bool) {
+	var lastKeyEvent *tcell.EventKey
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for time.Now().Before(startAt) {
+		drawBoard()
+		left := time.Until(startAt).Truncate(time.Second) + time.Second
+		drawPopup(s, g.bound, style, fmt.Sprintf("Starting in %d", int(left.Seconds())))
+		s.Show()
+
+		select {
+		case <-ticker.C:
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case *tcell.EventResize:
+				s.Sync()
+			case *tcell.EventKey:
+				if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC {
+					return nil, true
+				}
+
+				if _, arrow := key2Dir[ev.Key()]; arrow {
+					lastKeyEvent = ev
+				}
+			}
+		}
+	}
+
+	return lastKeyEvent, false
+}
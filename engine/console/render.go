@@ -0,0 +1,149 @@
+package console
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/kuredoro/snake_p2p/core"
+)
+
+// frameEvery is how often the screen is redrawn, regardless of the ticks.
+const frameEvery = time.Second / 30
+
+// leans are the half blocks the heads lean into the next cell with.
+var leans = map[core.Direction]rune{
+	core.Up:    '▄',
+	core.Right: '▌',
+	core.Down:  '▀',
+	core.Left:  '▐',
+}
+
+// drawLean animates the snakes between the ticks: in the second half of
+// the tick, the heads lean into the cells they are going to.
+func (g *GameUI) drawLean(s tcell.Screen, board tcell.Style) {
+	tickRate := g.tickRate()
+	if tickRate <= 0 || time.Since(g.moved) < tickRate/2 {
+		return
+	}
+
+	for id, snake := range g.view.Snakes {
+		if !snake.Alive || !snake.Moved {
+			continue
+		}
+
+		fg, _, _ := g.styles[id].Decompose()
+		next := g.view.Next(snake, snake.Dir)
+		s.SetContent(next.X, next.Y, leans[snake.Dir], nil, board.Foreground(fg))
+	}
+}
+
+// drawWaiting names the players the tick waits for, once it is late.
+func (g *GameUI) drawWaiting(s tcell.Screen, style tcell.Style) {
+	if g.Over || time.Since(g.stepped) < 2*g.tickRate() {
+		return
+	}
+
+	waiting := g.gi.Waiting()
+	if len(waiting) == 0 {
+		return
+	}
+
+	text := " waiting for"
+	for _, id := range waiting {
+		text += " " + shortID(id)
+	}
+	text += "… "
+
+	x1, x2 := g.bound.TopLeft.X, g.bound.BottomRight.X
+	y := g.bound.TopLeft.Y + 1
+	drawText(s, x1+2, y, x2, y, style, text)
+}
+
+// drawZone shades the board outside the zone, outlines the next zone and
+// counts down to the shrink on the bottom border.
+func (g *GameUI) drawZone(s tcell.Screen, board tcell.Style) {
+	w := g.view
+	if !w.Zoned() {
+		return
+	}
+
+	outside := board.Background(tcell.ColorMaroon)
+	for x := w.Bounds.TopLeft.X + 1; x < w.Bounds.BottomRight.X; x++ {
+		for y := w.Bounds.TopLeft.Y + 1; y < w.Bounds.BottomRight.Y; y++ {
+			if w.Zone.Outside(core.Coord{X: x, Y: y}) {
+				s.SetContent(x, y, ' ', nil, outside)
+			}
+		}
+	}
+
+	if w.NextZone == w.Zone {
+		return
+	}
+
+	next := w.NextZone
+	outline := board.Foreground(tcell.ColorYellow)
+	for x := next.TopLeft.X; x <= next.BottomRight.X; x++ {
+		s.SetContent(x, next.TopLeft.Y, tcell.RuneHLine, nil, outline)
+		s.SetContent(x, next.BottomRight.Y, tcell.RuneHLine, nil, outline)
+	}
+	for y := next.TopLeft.Y; y <= next.BottomRight.Y; y++ {
+		s.SetContent(next.TopLeft.X, y, tcell.RuneVLine, nil, outline)
+		s.SetContent(next.BottomRight.X, y, tcell.RuneVLine, nil, outline)
+	}
+
+	left := time.Duration(w.ShrinksIn())*g.tickRate() - time.Since(g.moved)
+	if left < 0 {
+		left = 0
+	}
+	text := fmt.Sprintf(" zone shrinks in %.1fs ", left.Seconds())
+
+	x2 := g.bound.BottomRight.X
+	y := g.bound.BottomRight.Y
+	drawText(s, x2-len(text)-1, y, x2, y, board, text)
+}
+
+// drawClock counts the moves left in a game with the time limit.
+func (g *GameUI) drawClock(s tcell.Screen, board tcell.Style) {
+	left := g.view.MovesLeft()
+	if g.view.TimeLimit == 0 || left < 0 {
+		return
+	}
+
+	text := fmt.Sprintf(" %d moves left ", left)
+	x1 := g.bound.TopLeft.X
+	y := g.bound.BottomRight.Y
+	drawText(s, x1+1, y, x1+1+len(text), y, board, text)
+}
+
+// drawWalls marks the edges that kill, the others wrap around.
+func (g *GameUI) drawWalls(s tcell.Screen, board tcell.Style) {
+	walls := g.view.Walls
+	wall := board.Foreground(tcell.ColorRed)
+	b := g.view.Bounds
+
+	for x := b.TopLeft.X + 1; x < b.BottomRight.X; x++ {
+		if walls&core.TopEdge != 0 {
+			s.SetContent(x, b.TopLeft.Y, tcell.RuneHLine, nil, wall)
+		}
+		if walls&core.BottomEdge != 0 {
+			s.SetContent(x, b.BottomRight.Y, tcell.RuneHLine, nil, wall)
+		}
+	}
+	for y := b.TopLeft.Y + 1; y < b.BottomRight.Y; y++ {
+		if walls&core.LeftEdge != 0 {
+			s.SetContent(b.TopLeft.X, y, tcell.RuneVLine, nil, wall)
+		}
+		if walls&core.RightEdge != 0 {
+			s.SetContent(b.BottomRight.X, y, tcell.RuneVLine, nil, wall)
+		}
+	}
+}
+
+// drawObstacles draws the wall cells of the map.
+func (g *GameUI) drawObstacles(s tcell.Screen, board tcell.Style) {
+	obstacle := board.Foreground(tcell.ColorGray)
+	for c := range g.view.Obstacles {
+		s.SetContent(c.X, c.Y, tcell.RuneBlock, nil, obstacle)
+	}
+}
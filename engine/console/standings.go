@@ -0,0 +1,43 @@
+package console
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// RoundBreak is how long the standings are shown before the next round of
+// a room starts.
+var RoundBreak = 5 * time.Second
+
+// drawStandings shows the scores of the room's players after the round.
+func (g *GameUI) drawStandings(s tcell.Screen, style tcell.Style) {
+	ranking := g.standings.Ranking()
+	lines := []string{
+		fmt.Sprintf("Standings after %d of %d rounds", g.standings.Played(), g.settings.Rounds),
+	}
+
+	for i, id := range ranking {
+		score := g.standings[id]
+		who := shortID(id)
+		if id == g.gi.SelfID() {
+			who = "you"
+		}
+
+		lines = append(lines, fmt.Sprintf("%d. %-6s %d wins %3d kills %4d length",
+			i+1, who, score.Wins, score.Kills, score.Length))
+	}
+
+	if g.standings.Decided(g.settings.Rounds) && len(ranking) > 0 {
+		winner := shortID(ranking[0]) + " wins"
+		if ranking[0] == g.gi.SelfID() {
+			winner = "You win"
+		}
+		lines = append(lines, winner+" the series!", "Enter: lobby")
+	} else {
+		lines = append(lines, "Enter: next round, Esc: leave the room")
+	}
+
+	drawPopup(s, g.bound, style, lines...)
+}
@@ -0,0 +1,81 @@
+package console
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+
+	"github.com/kuredoro/snake_p2p/core"
+)
+
+// deathNotice tells the chat how the snake died.
+func deathNotice(d core.PlayerDied) string {
+	who := shortID(d.SnakeID)
+	switch d.Cause {
+	case core.HeadOn:
+		return fmt.Sprintf("%s crashed head-on into %s", who, shortID(d.Killer))
+	case core.Body:
+		return fmt.Sprintf("%s ran into %s", who, shortID(d.Killer))
+	case core.Self:
+		return fmt.Sprintf("%s bit itself", who)
+	case core.Wall:
+		return fmt.Sprintf("%s hit a wall", who)
+	case core.OutOfZone:
+		return fmt.Sprintf("%s was caught outside the zone", who)
+	case core.Timeout:
+		return fmt.Sprintf("%s was evicted for missing moves", who)
+	case core.Disconnect:
+		return fmt.Sprintf("%s was evicted after disconnecting", who)
+	}
+	return fmt.Sprintf("%s was evicted", who)
+}
+
+// death is the short form of the death for the stats table.
+func death(d *core.PlayerDied) string {
+	if d == nil {
+		return "alive"
+	}
+
+	switch d.Cause {
+	case core.HeadOn:
+		return fmt.Sprintf("head-on %s @%d", shortID(d.Killer), d.Tick)
+	case core.Body:
+		return fmt.Sprintf("body of %s @%d", shortID(d.Killer), d.Tick)
+	}
+	return fmt.Sprintf("%v @%d", d.Cause, d.Tick)
+}
+
+// drawStats shows what the players have done in the game, on top of the
+// board.
+func (g *GameUI) drawStats(s tcell.Screen, style tcell.Style) {
+	lines := []string{
+		fmt.Sprintf("%-6s %6s %7s %5s %5s  %s", "player", "ticks", "max len", "eaten", "kills", "death"),
+	}
+
+	for _, stats := range g.world.Stats() {
+		who := shortID(stats.SnakeID)
+		if stats.SnakeID == g.gi.SelfID() {
+			who = "you"
+		}
+
+		lines = append(lines, fmt.Sprintf("%-6s %6d %7d %5d %5d  %s",
+			who, stats.Ticks, stats.MaxLength, stats.Eaten, stats.Kills, death(stats.Death)))
+	}
+
+	width := 0
+	for _, line := range lines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+	width += 4
+
+	cx := (g.bound.TopLeft.X + g.bound.BottomRight.X) / 2
+	x1, y1 := cx-width/2, g.bound.TopLeft.Y+1
+	x2, y2 := x1+width, y1+len(lines)+1
+
+	drawBox(s, Boundary{core.Coord{X: x1, Y: y1}, core.Coord{X: x2, Y: y2}}, style)
+	for i, line := range lines {
+		drawText(s, x1+2, y1+1+i, x2-1, y1+1+i, style, line)
+	}
+}
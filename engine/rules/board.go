@@ -0,0 +1,54 @@
+package rules
+
+import "github.com/kuredoro/snake_p2p/core"
+
+// useMap puts the obstacles and the zones of the map on the board.
+func (w *World) useMap(m *core.Map) {
+	cell := func(c core.Coord) core.Coord {
+		return core.Coord{
+			X: w.Bounds.TopLeft.X + 1 + c.X,
+			Y: w.Bounds.TopLeft.Y + 1 + c.Y,
+		}
+	}
+	cells := func(cs []core.Coord) []core.Coord {
+		out := make([]core.Coord, len(cs))
+		for i, c := range cs {
+			out[i] = cell(c)
+		}
+		return out
+	}
+
+	w.Obstacles = make(map[core.Coord]bool, len(m.Walls))
+	for _, c := range m.Walls {
+		w.Obstacles[cell(c)] = true
+	}
+
+	w.FoodZone = cells(m.Food)
+	w.spawns[0] = cells(m.Spawns)
+	for team, zone := range m.TeamSpawns {
+		w.spawns[team+1] = cells(zone)
+	}
+}
+
+// spawn picks a free cell of the spawn zone of the team, or the common
+// one for those without a team. If the map has no such zone or it is full,
+// the snake is placed anywhere.
+func (w *World) spawn(team int) (core.Coord, bool) {
+	zone := w.spawns[0]
+	if team > 0 && team < len(w.spawns) && len(w.spawns[team]) > 0 {
+		zone = w.spawns[team]
+	}
+
+	var free []core.Coord
+	for _, c := range zone {
+		if !w.occupied(c) {
+			free = append(free, c)
+		}
+	}
+
+	if len(free) == 0 {
+		return core.Coord{}, false
+	}
+
+	return free[w.rng.Intn(len(free))], true
+}
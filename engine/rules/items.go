@@ -0,0 +1,165 @@
+package rules
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Item is a food item on the board.
+type Item struct {
+	Pos  core.Coord
+	Kind core.ItemKind
+}
+
+const (
+	// SpeedTicks and GhostTicks are how long the power-ups last.
+	SpeedTicks = 20
+	GhostTicks = 30
+
+	// ShrinkBy is how many segments the rivals lose to the shrink.
+	ShrinkBy = 3
+)
+
+// itemOdds are the chances of the kinds of the items, out of their sum.
+var itemOdds = []struct {
+	kind core.ItemKind
+	odds int
+}{
+	{core.Food, 14},
+	{core.BigFood, 3},
+	{core.Speed, 1},
+	{core.Ghost, 1},
+	{core.Shrink, 1},
+}
+
+// itemKind draws the kind of the next item.
+func (w *World) itemKind() core.ItemKind {
+	if !w.PowerUps {
+		return core.Food
+	}
+
+	total := 0
+	for _, o := range itemOdds {
+		total += o.odds
+	}
+
+	n := w.rng.Intn(total)
+	for _, o := range itemOdds {
+		if n < o.odds {
+			return o.kind
+		}
+		n -= o.odds
+	}
+
+	return core.Food
+}
+
+// use applies the item the snake has eaten.
+func (w *World) use(id peer.ID, kind core.ItemKind) {
+	snake := w.Snakes[id]
+
+	switch kind {
+	case core.Food:
+		grow(snake, 1)
+	case core.BigFood:
+		grow(snake, 3)
+	case core.Speed:
+		snake.Speed = SpeedTicks
+		w.Events = append(w.Events, core.PowerUp{SnakeID: id, Kind: kind, Ticks: SpeedTicks})
+	case core.Ghost:
+		snake.Ghost = GhostTicks
+		w.Events = append(w.Events, core.PowerUp{SnakeID: id, Kind: kind, Ticks: GhostTicks})
+	case core.Shrink:
+		for rival, s := range w.Snakes {
+			if rival == id || !s.Alive || w.teammates(id, rival) {
+				continue
+			}
+
+			cut := ShrinkBy
+			if cut > len(s.Body) {
+				cut = len(s.Body)
+			}
+			s.Body = s.Body[:len(s.Body)-cut]
+		}
+		w.Events = append(w.Events, core.PowerUp{SnakeID: id, Kind: kind})
+	}
+}
+
+// grow adds the segments on the tail, they unfold as the snake moves.
+func grow(snake *Snake, n int) {
+	tail := snake.Head
+	if len(snake.Body) > 0 {
+		tail = snake.Body[len(snake.Body)-1]
+	}
+
+	for i := 0; i < n; i++ {
+		snake.Body = append(snake.Body, tail)
+	}
+}
+
+// boosted lists the snakes with the speed boost that are still alive.
+func (w *World) boosted(ids []peer.ID) []peer.ID {
+	var fast []peer.ID
+	for _, id := range ids {
+		if s := w.Snakes[id]; s.Alive && s.Speed > 0 {
+			fast = append(fast, id)
+		}
+	}
+
+	return fast
+}
+
+// moveAgain makes the boosted snakes move a second cell in the tick, and
+// returns the ones that died doing it.
+func (w *World) moveAgain(fast []peer.ID) []peer.ID {
+	heads := make(map[peer.ID]core.Coord, len(fast))
+	for _, id := range fast {
+		snake := w.Snakes[id]
+		heads[id] = w.Next(snake, snake.Dir)
+	}
+
+	died := w.bury(w.collide(fast, heads))
+	if w.checkOver() {
+		return died
+	}
+
+	for _, id := range fast {
+		if w.Snakes[id].Alive {
+			w.eat(id, heads[id])
+		}
+	}
+
+	for _, id := range fast {
+		if w.Snakes[id].Alive {
+			w.move(w.Snakes[id], heads[id])
+		}
+	}
+
+	return died
+}
+
+// wearOff counts the ticks of the power-ups down.
+func (w *World) wearOff(ids []peer.ID) {
+	sorted := append([]peer.ID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	for _, id := range sorted {
+		snake := w.Snakes[id]
+		if snake.Speed > 0 {
+			snake.Speed--
+			if snake.Speed == 0 {
+				w.Events = append(w.Events, core.PowerDown{SnakeID: id, Kind: core.Speed})
+			}
+		}
+		if snake.Ghost > 0 {
+			snake.Ghost--
+			if snake.Ghost == 0 {
+				w.Events = append(w.Events, core.PowerDown{SnakeID: id, Kind: core.Ghost})
+			}
+		}
+	}
+}
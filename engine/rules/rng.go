@@ -0,0 +1,31 @@
+package rules
+
+// rng is a xorshift64* generator. Unlike math/rand, its state is a plain
+// value, so the world can be copied with it.
+type rng struct {
+	state uint64
+}
+
+func newRNG(seed int64) rng {
+	state := uint64(seed)
+	if state == 0 {
+		state = 0x9e3779b97f4a7c15
+	}
+
+	return rng{state: state}
+}
+
+func (r *rng) next() uint64 {
+	x := r.state
+	x ^= x >> 12
+	x ^= x << 25
+	x ^= x >> 27
+	r.state = x
+
+	return x * 2685821657736338717
+}
+
+// Intn returns a number in [0, n).
+func (r *rng) Intn(n int) int {
+	return int(r.next() % uint64(n))
+}
@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Score is what the player has achieved over the rounds of a room.
+type Score struct {
+	Rounds int
+	Wins   int
+	Kills  int
+	Length int // summed over the rounds
+}
+
+// Standings are the scores of the players of a room, they are kept by
+// every peer from the worlds it has seen to the end.
+type Standings map[peer.ID]*Score
+
+// Add counts the finished round in.
+func (s Standings) Add(w *World) {
+	for id, snake := range w.Snakes {
+		score, exists := s[id]
+		if !exists {
+			score = &Score{}
+			s[id] = score
+		}
+
+		score.Rounds++
+		if stats, exists := w.stats[id]; exists {
+			score.Kills += stats.Kills
+		}
+		score.Length += len(snake.Body) + 1
+	}
+
+	if w.Successful && w.WinnerTeam != 0 {
+		for id, team := range w.Teams {
+			if score, exists := s[id]; exists && team == w.WinnerTeam {
+				score.Wins++
+			}
+		}
+	} else if w.Successful {
+		if score, exists := s[w.WinnerID]; exists {
+			score.Wins++
+		}
+	}
+}
+
+// Copy returns standings that do not share the scores.
+func (s Standings) Copy() Standings {
+	c := make(Standings, len(s))
+	for id, score := range s {
+		sc := *score
+		c[id] = &sc
+	}
+
+	return c
+}
+
+// Ranking sorts the players by wins, then by kills, then by length.
+func (s Standings) Ranking() []peer.ID {
+	ids := make([]peer.ID, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := s[ids[i]], s[ids[j]]
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		if a.Length != b.Length {
+			return a.Length > b.Length
+		}
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// Played is how many rounds the room has played, as far as we know.
+func (s Standings) Played() int {
+	played := 0
+	for _, score := range s {
+		if score.Rounds > played {
+			played = score.Rounds
+		}
+	}
+
+	return played
+}
+
+// Decided tells whether the best-of-rounds series is over: all the rounds
+// are played, or a player has won the majority of them.
+func (s Standings) Decided(rounds int) bool {
+	if s.Played() >= rounds {
+		return true
+	}
+
+	for _, score := range s {
+		if score.Wins*2 > rounds {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package rules
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// die kills the snake and records how. The kill is credited to the
+// killer, unless they are teammates.
+func (w *World) die(d core.PlayerDied) {
+	w.Snakes[d.SnakeID].Alive = false
+	w.AliveSnakes--
+
+	d.Tick = w.MoveNum
+	if stats, exists := w.stats[d.SnakeID]; exists {
+		stats.Death = &d
+	}
+	if stats, exists := w.stats[d.Killer]; exists && !w.teammates(d.SnakeID, d.Killer) {
+		stats.Kills++
+	}
+
+	w.Events = append(w.Events, d)
+}
+
+// bury kills the snakes and returns who they were.
+func (w *World) bury(deaths []core.PlayerDied) []peer.ID {
+	ids := make([]peer.ID, 0, len(deaths))
+	for _, d := range deaths {
+		w.die(d)
+		ids = append(ids, d.SnakeID)
+	}
+
+	return ids
+}
+
+// track counts the move in the stats of the snakes still alive.
+func (w *World) track() {
+	for id, snake := range w.Snakes {
+		stats, exists := w.stats[id]
+		if !exists || !snake.Alive {
+			continue
+		}
+
+		stats.Ticks = w.MoveNum
+		if length := len(snake.Body) + 1; length > stats.MaxLength {
+			stats.MaxLength = length
+		}
+	}
+}
+
+// Stats are what the players have done so far, in the order of their IDs.
+func (w *World) Stats() []core.PlayerStats {
+	stats := make([]core.PlayerStats, 0, len(w.stats))
+	for _, s := range w.stats {
+		stats = append(stats, *s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].SnakeID < stats[j].SnakeID
+	})
+	return stats
+}
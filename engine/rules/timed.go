@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TimeUp tells whether the time limit has been reached.
+func (w *World) TimeUp() bool {
+	return w.TimeLimit > 0 && w.MoveNum >= w.TimeLimit
+}
+
+// MovesLeft is how many moves are left until the time is up.
+func (w *World) MovesLeft() int {
+	if w.TimeLimit == 0 {
+		return 0
+	}
+	return w.TimeLimit - w.MoveNum
+}
+
+// Scores are the lengths of the snakes, the longest first. The dead
+// snakes keep the length they had when they died.
+func (w *World) Scores() []core.PlayerScore {
+	scores := make([]core.PlayerScore, 0, len(w.Snakes))
+	for id, snake := range w.Snakes {
+		scores = append(scores, core.PlayerScore{
+			SnakeID: id,
+			Length:  len(snake.Body) + 1,
+			Alive:   snake.Alive,
+		})
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Length != scores[j].Length {
+			return scores[i].Length > scores[j].Length
+		}
+		return scores[i].SnakeID < scores[j].SnakeID
+	})
+	return scores
+}
+
+// timeUp ends the game: the longest snake alive wins, or the team whose
+// snakes alive are the longest together. A tie is a draw.
+func (w *World) timeUp() {
+	w.Over = true
+	w.Successful = true
+
+	if w.Teams != nil {
+		lengths := make(map[int]int)
+		for id, snake := range w.Snakes {
+			if snake.Alive {
+				lengths[w.Teams[id]] += len(snake.Body) + 1
+			}
+		}
+
+		best := 0
+		for team, length := range lengths {
+			switch {
+			case length > best:
+				best = length
+				w.WinnerTeam = team
+			case length == best:
+				w.WinnerTeam = 0
+			}
+		}
+		return
+	}
+
+	best := 0
+	var winner peer.ID
+	for _, score := range w.Scores() {
+		if !score.Alive {
+			continue
+		}
+		switch {
+		case score.Length > best:
+			best = score.Length
+			winner = score.SnakeID
+		case score.Length == best:
+			winner = ""
+		}
+	}
+	w.WinnerID = winner
+}
@@ -0,0 +1,71 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// timedWorld is a world of two snakes side by side, heading right, that
+// runs out of time after the moves. The first snake is the longer one if
+// longer is set.
+func timedWorld(moves int, longer bool) *World {
+	settings := core.DefaultGameSettings()
+	settings.TimeLimit = moves
+
+	w := NewWorld(settings, nil, []peer.ID{"a", "b"}, nil, 1)
+	w.Snakes["a"].Head = core.Coord{X: 10, Y: 10}
+	w.Snakes["b"].Head = core.Coord{X: 10, Y: 20}
+	if longer {
+		w.Snakes["a"].Body = []core.Coord{{X: 9, Y: 10}}
+	}
+
+	return w
+}
+
+func TestTimeUp(t *testing.T) {
+	cases := []struct {
+		name   string
+		longer bool
+		winner peer.ID
+	}{
+		{"longest wins", true, "a"},
+		{"tie is a draw", false, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			const limit = 3
+			w := timedWorld(limit, c.longer)
+			moves := map[peer.ID]core.Direction{"a": core.Right, "b": core.Right}
+
+			for i := 0; i < limit; i++ {
+				if w.Over {
+					t.Fatalf("over after %d moves, want %d", i, limit)
+				}
+
+				// Nobody grows on the way.
+				w.Food = make(map[int]Item)
+
+				if died := w.Step(moves); len(died) != 0 {
+					t.Fatalf("move %d: died %v, want none", i, died)
+				}
+			}
+
+			if !w.Over || !w.TimeUp() {
+				t.Fatalf("over=%v time up=%v after the limit, want both", w.Over, w.TimeUp())
+			}
+			if !w.Successful || w.WinnerID != c.winner {
+				t.Errorf("successful=%v winner=%q, want true and %q", w.Successful, w.WinnerID, c.winner)
+			}
+			if w.MovesLeft() != 0 {
+				t.Errorf("%d moves left, want 0", w.MovesLeft())
+			}
+
+			if w.Step(moves); w.MoveNum != limit {
+				t.Errorf("moved on to %d after the game is over", w.MoveNum)
+			}
+		})
+	}
+}
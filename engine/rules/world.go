@@ -0,0 +1,539 @@
+// Package rules is the snake game itself, without the screen and the
+// network. Given the same settings, players, seed and moves, every peer
+// arrives at the same world.
+package rules
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// Bounds are the coordinates of the border, the snakes live strictly
+// inside.
+type Bounds struct {
+	TopLeft     core.Coord
+	BottomRight core.Coord
+}
+
+// Outside tells whether the coordinate is on the border or beyond it.
+func (b Bounds) Outside(c core.Coord) bool {
+	return c.X <= b.TopLeft.X || c.X >= b.BottomRight.X ||
+		c.Y <= b.TopLeft.Y || c.Y >= b.BottomRight.Y
+}
+
+type Snake struct {
+	Alive bool
+	Body  []core.Coord
+	Head  core.Coord
+	Dir   core.Direction
+	Moved bool
+
+	// Speed and Ghost are the ticks left of the power-ups.
+	Speed int
+	Ghost int
+}
+
+var shift = map[core.Direction]core.Coord{
+	core.Left:  {X: -1, Y: 0},
+	core.Right: {X: 1, Y: 0},
+	core.Up:    {X: 0, Y: -1},
+	core.Down:  {X: 0, Y: 1},
+}
+
+type World struct {
+	Bounds      Bounds
+	FoodEvery   int
+	Snakes      map[peer.ID]*Snake
+	Food        map[int]Item
+	MoveNum     int
+	AliveSnakes int
+	Over        bool
+	Successful  bool
+	WinnerID    peer.ID
+
+	// stats are what the players have done so far, refer to Stats.
+	stats map[peer.ID]*core.PlayerStats
+
+	// Teams is the team of every player in the team mode, nil otherwise.
+	// It is shared by the copies of the world.
+	Teams      map[peer.ID]int
+	TeamPass   bool
+	WinnerTeam int
+
+	// Zone is where the snakes may be, it shrinks to NextZone every
+	// ShrinkEvery moves. Without the shrinking, it is the whole board.
+	Zone        Bounds
+	NextZone    Bounds
+	ShrinkEvery int
+
+	// Walls are the edges that do not wrap, a head on them is out of the
+	// zone.
+	Walls core.Edges
+
+	// Obstacles are the wall cells of the map, and FoodZone is where the
+	// food appears if the map limits it. Both are shared by the copies of
+	// the world.
+	Obstacles map[core.Coord]bool
+	FoodZone  []core.Coord
+	spawns    [core.MaxTeams + 1][]core.Coord
+
+	// PowerUps makes some of the food special. Events are what happened
+	// in the last step: core.NewFood, core.FoodEaten, core.PowerUp and
+	// core.PowerDown.
+	PowerUps bool
+	Events   []interface{}
+
+	// TimeLimit is the move the game ends on, zero for none.
+	TimeLimit int
+
+	foodLastID int
+	rng        rng
+}
+
+// NewWorld places the snakes of the players at random, in the spawn zones
+// of the map if it has them. Otherwise in the team mode, every team starts
+// in a strip of the board of its own. The map is nil for the open board.
+func NewWorld(settings core.GameSettings, board *core.Map, players []peer.ID, teams map[peer.ID]int, seed int64) *World {
+	w := &World{
+		Bounds: Bounds{
+			TopLeft:     core.Coord{X: 1, Y: 1},
+			BottomRight: core.Coord{X: 1 + settings.Width, Y: 1 + settings.Height},
+		},
+		FoodEvery: settings.FoodEvery,
+		Snakes:    make(map[peer.ID]*Snake),
+		Food:      make(map[int]Item),
+		stats:     make(map[peer.ID]*core.PlayerStats),
+		Teams:     teams,
+		TeamPass:  settings.TeamPass,
+		rng:       newRNG(seed),
+	}
+	w.Zone = w.Bounds
+	w.NextZone = w.Bounds
+	w.ShrinkEvery = settings.ShrinkEvery
+	w.Walls = settings.Walls
+	w.PowerUps = settings.PowerUps
+	w.TimeLimit = settings.TimeLimit
+	if board != nil {
+		w.useMap(board)
+	}
+
+	ids := append([]peer.ID(nil), players...)
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	width := w.Bounds.BottomRight.X - w.Bounds.TopLeft.X - 1
+	height := w.Bounds.BottomRight.Y - w.Bounds.TopLeft.Y - 1
+
+	strips := 0
+	for _, team := range teams {
+		if team > strips {
+			strips = team
+		}
+	}
+
+	for _, id := range ids {
+		left, span := 0, width
+		if strips > 0 && teams[id] > 0 {
+			span = width / strips
+			left = (teams[id] - 1) * span
+		}
+
+		start, spawned := w.spawn(teams[id])
+		for !spawned {
+			start = core.Coord{
+				X: w.Bounds.TopLeft.X + 1 + left + w.rng.Intn(span),
+				Y: w.Bounds.TopLeft.Y + 1 + w.rng.Intn(height),
+			}
+			spawned = !w.occupied(start)
+		}
+		w.Snakes[id] = &Snake{Alive: true, Head: start}
+		w.stats[id] = &core.PlayerStats{SnakeID: id, MaxLength: 1}
+	}
+	w.AliveSnakes = len(w.Snakes)
+
+	if w.Zoned() {
+		w.planZone()
+	}
+
+	return w
+}
+
+// Snapshot is a copy of the world to return to.
+type Snapshot struct {
+	w *World
+}
+
+func (w *World) Snapshot() Snapshot {
+	return Snapshot{w: w.clone()}
+}
+
+func (w *World) Restore(s Snapshot) {
+	*w = *s.w.clone()
+}
+
+func (w *World) clone() *World {
+	c := *w
+
+	c.Snakes = make(map[peer.ID]*Snake, len(w.Snakes))
+	for id, snake := range w.Snakes {
+		s := *snake
+		s.Body = append([]core.Coord(nil), snake.Body...)
+		c.Snakes[id] = &s
+	}
+
+	c.Food = make(map[int]Item, len(w.Food))
+	for id, f := range w.Food {
+		c.Food[id] = f
+	}
+
+	c.stats = make(map[peer.ID]*core.PlayerStats, len(w.stats))
+	for id, stats := range w.stats {
+		s := *stats
+		c.stats[id] = &s
+	}
+
+	c.Events = nil
+
+	return &c
+}
+
+// Wrap moves the coordinate on the border to the other side of the board,
+// unless the edge is a wall.
+func (w *World) Wrap(c core.Coord) core.Coord {
+	b := w.Bounds
+	if c.X == b.TopLeft.X && w.Walls&core.LeftEdge == 0 {
+		c.X = b.BottomRight.X - 1
+	}
+	if c.X == b.BottomRight.X && w.Walls&core.RightEdge == 0 {
+		c.X = b.TopLeft.X + 1
+	}
+	if c.Y == b.TopLeft.Y && w.Walls&core.TopEdge == 0 {
+		c.Y = b.BottomRight.Y - 1
+	}
+	if c.Y == b.BottomRight.Y && w.Walls&core.BottomEdge == 0 {
+		c.Y = b.TopLeft.Y + 1
+	}
+	return c
+}
+
+// Next returns where the snake's head goes in the direction.
+func (w *World) Next(snake *Snake, dir core.Direction) core.Coord {
+	head := snake.Head
+	head.X += shift[dir].X
+	head.Y += shift[dir].Y
+	return w.Wrap(head)
+}
+
+// Kill removes the player's snake from the game, for example when the
+// player is evicted.
+func (w *World) Kill(p peer.ID, cause core.DeathCause) bool {
+	snake, exists := w.Snakes[p]
+	if !exists || !snake.Alive {
+		return false
+	}
+
+	w.die(core.PlayerDied{SnakeID: p, Cause: cause})
+	w.checkOver()
+
+	return true
+}
+
+// Step moves the alive snakes and returns the ones that died.
+func (w *World) Step(moves map[peer.ID]core.Direction) []peer.ID {
+	w.Events = nil
+
+	ids := make([]peer.ID, 0, len(moves))
+	heads := make(map[peer.ID]core.Coord, len(moves))
+	for id, dir := range moves {
+		snake, exists := w.Snakes[id]
+		if !exists || !snake.Alive {
+			continue
+		}
+
+		if _, known := shift[dir]; !known {
+			// The game instance has already replaced invalid moves.
+			log.Error().Str("player", id.Pretty()).Int("dir", int(dir)).Msg("Unknown direction")
+			continue
+		}
+
+		snake.Dir = dir
+		snake.Moved = true
+		heads[id] = w.Next(snake, dir)
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	died := w.bury(w.collide(ids, heads))
+	if w.checkOver() {
+		return died
+	}
+
+	for _, id := range ids {
+		if w.Snakes[id].Alive {
+			w.eat(id, heads[id])
+		}
+	}
+
+	for _, id := range ids {
+		if w.Snakes[id].Alive {
+			w.move(w.Snakes[id], heads[id])
+		}
+	}
+
+	if fast := w.boosted(ids); len(fast) > 0 {
+		died = append(died, w.moveAgain(fast)...)
+		if w.Over {
+			return died
+		}
+	}
+
+	w.newFood()
+	w.MoveNum++
+	w.wearOff(ids)
+	w.track()
+
+	if w.Zoned() && w.MoveNum%w.ShrinkEvery == 0 {
+		died = append(died, w.shrink()...)
+		w.checkOver()
+	}
+
+	if w.TimeUp() && !w.Over {
+		w.timeUp()
+	}
+
+	return died
+}
+
+// collide finds the snakes that run into another head, into a snake, into
+// an obstacle or out of the zone, which includes running into a wall.
+// The killer is the owner of the snake run into, in a head-on collision
+// both players are.
+func (w *World) collide(ids []peer.ID, heads map[peer.ID]core.Coord) []core.PlayerDied {
+	var deaths []core.PlayerDied
+
+	owners := make([]peer.ID, 0, len(w.Snakes))
+	for id := range w.Snakes {
+		owners = append(owners, id)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i] < owners[j]
+	})
+
+	for _, id := range ids {
+		head := heads[id]
+		dead := false
+		var killer peer.ID
+		var cause core.DeathCause
+		ghost := w.Snakes[id].Ghost > 0
+
+		if w.Bounds.Outside(head) || w.Obstacles[head] {
+			dead = true
+			cause = core.Wall
+		} else if w.Zone.Outside(head) {
+			dead = true
+			cause = core.OutOfZone
+		}
+
+		for _, other := range ids {
+			if other != id && !ghost && !w.passes(id, other) && core.EqualCoord(head, heads[other]) {
+				dead = true
+				killer = other
+				cause = core.HeadOn
+			}
+		}
+
+		for _, owner := range owners {
+			snake := w.Snakes[owner]
+			if !snake.Alive || ghost || (owner != id && w.passes(id, owner)) {
+				continue
+			}
+
+			hit := core.EqualCoord(snake.Head, head)
+			for _, b := range snake.Body {
+				if core.EqualCoord(b, head) {
+					hit = true
+				}
+			}
+
+			if hit {
+				dead = true
+				if owner != id {
+					killer = owner
+					cause = core.Body
+				} else if killer == "" {
+					cause = core.Self
+				}
+			}
+		}
+
+		if dead {
+			deaths = append(deaths, core.PlayerDied{SnakeID: id, Cause: cause, Killer: killer})
+		}
+	}
+
+	return deaths
+}
+
+// teammates tells whether the players are in the same team.
+func (w *World) teammates(a, b peer.ID) bool {
+	return w.Teams != nil && w.Teams[a] == w.Teams[b]
+}
+
+// passes tells whether the snakes go through each other.
+func (w *World) passes(a, b peer.ID) bool {
+	return w.TeamPass && w.teammates(a, b)
+}
+
+func (w *World) eat(id peer.ID, head core.Coord) {
+	for foodID, item := range w.Food {
+		if !core.EqualCoord(head, item.Pos) {
+			continue
+		}
+
+		delete(w.Food, foodID)
+		w.stats[id].Eaten++
+		w.Events = append(w.Events, core.FoodEaten{FoodID: foodID, SnakeID: id, Kind: item.Kind})
+		log.Debug().Msgf("Food (%v) on (%d, %d) eaten by %s", item.Kind, head.X, head.Y, id.Pretty())
+
+		w.use(id, item.Kind)
+	}
+}
+
+func (w *World) move(snake *Snake, head core.Coord) {
+	prevHead := snake.Head
+	snake.Head = head
+
+	if len(snake.Body) == 0 {
+		return
+	}
+	for i := len(snake.Body) - 1; i > 0; i-- {
+		snake.Body[i] = snake.Body[i-1]
+	}
+	snake.Body[0] = prevHead
+}
+
+func (w *World) occupied(c core.Coord) bool {
+	if w.Obstacles[c] {
+		return true
+	}
+
+	for _, snake := range w.Snakes {
+		if !snake.Alive {
+			continue
+		}
+		if core.EqualCoord(snake.Head, c) {
+			return true
+		}
+		for _, b := range snake.Body {
+			if core.EqualCoord(b, c) {
+				return true
+			}
+		}
+	}
+
+	for _, f := range w.Food {
+		if core.EqualCoord(f.Pos, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newFood places a food item on a random free cell of the zone every
+// FoodEvery moves.
+func (w *World) newFood() {
+	if w.MoveNum%w.FoodEvery != 0 {
+		return
+	}
+
+	b := w.Zone
+	var free []core.Coord
+	if len(w.FoodZone) > 0 {
+		for _, c := range w.FoodZone {
+			if !b.Outside(c) && !w.occupied(c) {
+				free = append(free, c)
+			}
+		}
+	} else {
+		for x := b.TopLeft.X + 1; x < b.BottomRight.X; x++ {
+			for y := b.TopLeft.Y + 1; y < b.BottomRight.Y; y++ {
+				c := core.Coord{X: x, Y: y}
+				if !w.occupied(c) {
+					free = append(free, c)
+				}
+			}
+		}
+	}
+
+	if len(free) == 0 {
+		return
+	}
+
+	pos := free[w.rng.Intn(len(free))]
+	kind := w.itemKind()
+	w.Food[w.foodLastID] = Item{Pos: pos, Kind: kind}
+	w.Events = append(w.Events, core.NewFood{FoodID: w.foodLastID, Pos: pos, Kind: kind})
+	w.foodLastID++
+	log.Debug().Msgf("New %v on (%d, %d)", kind, pos.X, pos.Y)
+}
+
+// Result is the outcome of the game once it is over.
+func (w *World) Result() core.GameOver {
+	return core.GameOver{
+		Successful: w.Successful,
+		Scores:     w.Scores(),
+		Stats:      w.Stats(),
+		Team:       w.WinnerTeam,
+	}
+}
+
+func (w *World) checkOver() bool {
+	if w.Teams != nil {
+		return w.checkTeamsOver()
+	}
+
+	if w.AliveSnakes == 1 {
+		w.Over = true
+		w.Successful = true
+		for id, snake := range w.Snakes {
+			if snake.Alive {
+				w.WinnerID = id
+				break
+			}
+		}
+	} else if w.AliveSnakes < 1 {
+		w.Over = true
+		w.Successful = false
+	}
+	return w.Over
+}
+
+// checkTeamsOver ends the game when only one team has snakes left.
+func (w *World) checkTeamsOver() bool {
+	alive := make(map[int]bool)
+	for id, snake := range w.Snakes {
+		if snake.Alive {
+			alive[w.Teams[id]] = true
+		}
+	}
+
+	switch len(alive) {
+	case 0:
+		w.Over = true
+		w.Successful = false
+	case 1:
+		w.Over = true
+		w.Successful = true
+		for team := range alive {
+			w.WinnerTeam = team
+		}
+	}
+	return w.Over
+}
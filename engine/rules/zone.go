@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// minZone is the smallest size of the zone, it stops shrinking there.
+const minZone = 8
+
+// Zoned tells whether the playable zone shrinks in this game.
+func (w *World) Zoned() bool {
+	return w.ShrinkEvery > 0
+}
+
+// ShrinksIn is how many moves are left before the zone shrinks to
+// NextZone.
+func (w *World) ShrinksIn() int {
+	return w.ShrinkEvery - w.MoveNum%w.ShrinkEvery
+}
+
+// planZone picks the next zone: a quarter smaller, somewhere inside the
+// current one. The world's generator makes every peer pick the same.
+func (w *World) planZone() {
+	z := w.Zone
+	width := z.BottomRight.X - z.TopLeft.X
+	height := z.BottomRight.Y - z.TopLeft.Y
+
+	next := func(size int) int {
+		size -= size / 4
+		if size < minZone {
+			size = minZone
+		}
+		return size
+	}
+	nextWidth, nextHeight := next(width), next(height)
+	if nextWidth >= width && nextHeight >= height {
+		w.NextZone = z
+		return
+	}
+	if nextWidth > width {
+		nextWidth = width
+	}
+	if nextHeight > height {
+		nextHeight = height
+	}
+
+	topLeft := core.Coord{
+		X: z.TopLeft.X + w.rng.Intn(width-nextWidth+1),
+		Y: z.TopLeft.Y + w.rng.Intn(height-nextHeight+1),
+	}
+	w.NextZone = Bounds{
+		TopLeft:     topLeft,
+		BottomRight: core.Coord{X: topLeft.X + nextWidth, Y: topLeft.Y + nextHeight},
+	}
+}
+
+// shrink moves the zone in and returns the snakes it has left outside,
+// they die. The food outside is gone as well.
+func (w *World) shrink() []peer.ID {
+	w.Zone = w.NextZone
+	w.planZone()
+
+	for id, f := range w.Food {
+		if w.Zone.Outside(f.Pos) {
+			delete(w.Food, id)
+		}
+	}
+
+	ids := make([]peer.ID, 0, len(w.Snakes))
+	for id, snake := range w.Snakes {
+		if snake.Alive && w.Zone.Outside(snake.Head) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		w.die(core.PlayerDied{SnakeID: id, Cause: core.OutOfZone})
+	}
+
+	return ids
+}
@@ -3,9 +3,11 @@ package snake_p2p
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/hashicorp/go-multierror"
 	libp2p "github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -13,6 +15,8 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/rs/zerolog/log"
 
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
 	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/kuredoro/snake_p2p/protocol/gather"
 )
@@ -35,11 +39,15 @@ type Node struct {
 	addrInfo *peer.AddrInfo
 	ping     *ping.PingService
 	game     *game.GameService
+	chat     *chat.LobbyService
 
 	joinedGatherPoints            map[peer.ID]*gather.JoinService
 	gatherService                 *gather.GatherService
+	room                          *gather.RoomService
 	GatherPoints                  chan *gather.GatherPointMessage
+	Chat                          chan chat.Message
 	EstablishedGames, gameProxyCh chan game.GameEstablished
+	FailedJoins, failProxyCh      chan gather.JoinFailed
 }
 
 func New(ctx context.Context) (*Node, error) {
@@ -74,29 +82,49 @@ func New(ctx context.Context) (*Node, error) {
 
 	log.Info().Msg("Joined the pub/sub network")
 
+	chatCh := make(chan chat.Message, 32)
+	lobby, err := chat.NewLobbyService(ps, h.ID(), chatCh)
+	if err != nil {
+		return nil, fmt.Errorf("join lobby chat: %v", err)
+	}
+
+	pingService := ping.NewPingService(h)
+
 	n := &Node{
 		h:                  h,
 		ps:                 ps,
 		topic:              topic,
 		sub:                sub,
 		addrInfo:           HostAddrInfo(h),
-		ping:               ping.NewPingService(h),
-		game:               game.NewGameService(h),
+		ping:               pingService,
+		game:               game.NewGameService(h, pingService),
+		chat:               lobby,
 		joinedGatherPoints: make(map[peer.ID]*gather.JoinService),
 		GatherPoints:       make(chan *gather.GatherPointMessage, 32),
+		Chat:               chatCh,
 		EstablishedGames:   make(chan game.GameEstablished),
 		gameProxyCh:        make(chan game.GameEstablished),
+		FailedJoins:        make(chan gather.JoinFailed, 8),
+		failProxyCh:        make(chan gather.JoinFailed),
 	}
 
 	go n.readLoop()
 	return n, nil
 }
 
+func (n *Node) ID() peer.ID {
+	return n.h.ID()
+}
+
 func (n *Node) Close() {
 	if n.gatherService != nil {
 		log.Debug().Msg("Closing gathering service")
 		n.gatherService.Close()
 	}
+	if n.room != nil {
+		log.Debug().Msg("Closing room service")
+		n.room.Close()
+	}
 	for i, js := range n.joinedGatherPoints {
 		log.Debug().
 			Str("facilitator", i.Pretty()).
@@ -104,6 +132,8 @@ func (n *Node) Close() {
 		js.Close()
 	}
 
+	n.chat.Close()
+
 	log.Debug().Msg("Closing libp2p host")
 	err := n.h.Close()
 	if err != nil {
@@ -113,7 +143,9 @@ func (n *Node) Close() {
 	log.Info().Msg("Snake node closed")
 }
 
-func (n *Node) JoinGatherPoint(ctx context.Context, pi peer.AddrInfo) error {
+// JoinGatherPoint joins the gather point hosted by pi. The passphrase
+// is only needed for protected gather points.
+func (n *Node) JoinGatherPoint(ctx context.Context, pi peer.AddrInfo, passphrase string) error {
 	if _, joined := n.joinedGatherPoints[pi.ID]; joined {
 		return nil
 	}
@@ -123,9 +155,9 @@ func (n *Node) JoinGatherPoint(ctx context.Context, pi peer.AddrInfo) error {
 		return fmt.Errorf("join gather point: %v", err)
 	}
 
-	service, err := gather.NewJoinService(ctx, n.h, n.game, n.ping, pi.ID, n.gameProxyCh)
+	service, err := gather.NewJoinService(ctx, n.h, n.game, n.ping, pi.ID, passphrase, n.Chat, n.gameProxyCh, n.failProxyCh)
 	if err != nil {
-		return fmt.Errorf("create join service for peer %v: %v", pi.ID.ShortString(), err)
+		return fmt.Errorf("create join service for peer %v: %w", pi.ID.ShortString(), err)
 	}
 
 	n.joinedGatherPoints[pi.ID] = service
@@ -137,8 +169,14 @@ func (n *Node) JoinGatherPoint(ctx context.Context, pi peer.AddrInfo) error {
 	return nil
 }
 
-func (n *Node) CreateGatherPoint(playerCount int, TTL time.Duration) (err error) {
-	n.gatherService, err = gather.NewGatherService(n.h, n.topic, n.game, n.ping, playerCount, TTL, n.gameProxyCh)
+func (n *Node) CreateGatherPoint(playerCount int, TTL time.Duration, access gather.Access, settings core.GameSettings) (err error) {
+	// The gather point is replaced, along with its game.
+	if n.gatherService != nil {
+		n.gatherService.Close()
+		n.gatherService = nil
+	}
+
+	n.gatherService, err = gather.NewGatherService(n.h, n.topic, n.game, n.ping, playerCount, TTL, access, settings, n.Chat, n.gameProxyCh)
 	if err != nil {
 		return fmt.Errorf("create gather point: %v", err)
 	}
@@ -148,6 +186,50 @@ func (n *Node) CreateGatherPoint(playerCount int, TTL time.Duration) (err error)
 	return nil
 }
 
+// EndGame closes the finished game, so that the node is ready for another
+// one.
+func (n *Node) EndGame(id game.GameID) {
+	if n.room != nil && n.room.GameID() == id {
+		n.room.Close()
+		n.room = nil
+	}
+
+	n.game.End(id)
+}
+
+// SendLobbyChat sends the text to everybody in the pub/sub network.
+func (n *Node) SendLobbyChat(ctx context.Context, text string) error {
+	return n.chat.Send(ctx, text)
+}
+
+// SendGatherChat sends the text to the members of the gather points we
+// host or have joined.
+func (n *Node) SendGatherChat(text string) (merr error) {
+	if n.gatherService == nil && len(n.joinedGatherPoints) == 0 {
+		return errors.New("no gather point to chat in")
+	}
+
+	if n.gatherService != nil {
+		if err := n.gatherService.SendChat(text); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+
+	for id, js := range n.joinedGatherPoints {
+		if err := js.SendChat(text); err != nil {
+			merr = multierror.Append(merr, &core.PeerError{Peer: id, Err: err})
+		}
+	}
+
+	return
+}
+
+// Invite returns an invite to this node's gather point. It is the only
+// way to join a hidden gather point.
+func (n *Node) Invite() string {
+	return gather.FormatInvite(*n.addrInfo)
+}
+
 func (n *Node) readLoop() {
 	subCh := make(chan *pubsub.Message)
 	defer close(subCh)
@@ -192,8 +274,14 @@ func (n *Node) readLoop() {
 
 			n.GatherPoints <- msg
 		case info := <-n.gameProxyCh:
+			// The games of the other gather points will not take place,
+			// closing the services ends them.
 			if n.gatherService != nil {
 				n.gatherService.Close()
+				if n.gatherService.GameID() == info.ID && info.Settings.Room() {
+					n.room = n.gatherService.OpenRoom()
+				}
+				n.gatherService = nil
 			}
 
 			for _, s := range n.joinedGatherPoints {
@@ -203,6 +291,14 @@ func (n *Node) readLoop() {
 			n.joinedGatherPoints = make(map[peer.ID]*gather.JoinService)
 
 			n.EstablishedGames <- info
+		case failed := <-n.failProxyCh:
+			// The join service is done, a new one is needed to try again.
+			if js, exists := n.joinedGatherPoints[failed.Facilitator]; exists {
+				js.Close()
+				delete(n.joinedGatherPoints, failed.Facilitator)
+			}
+
+			n.FailedJoins <- failed
 		}
 	}
 }
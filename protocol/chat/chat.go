@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxLength is the maximum size of a chat message in bytes.
+const MaxLength = 256
+
+var (
+	ErrEmpty       = errors.New("message is empty")
+	ErrTooLong     = errors.New("message is too long")
+	ErrInvalid     = errors.New("message is not valid UTF-8")
+	ErrRateLimited = errors.New("too many messages, slow down")
+)
+
+type Scope int
+
+const (
+	Lobby Scope = iota
+	GatherPoint
+	Game
+)
+
+func (s Scope) String() string {
+	switch s {
+	case Lobby:
+		return "lobby"
+	case GatherPoint:
+		return "gather"
+	case Game:
+		return "game"
+	default:
+		return "unknown"
+	}
+}
+
+type Message struct {
+	Scope Scope
+	From  peer.ID
+	Text  string
+}
+
+// Validate checks that the text can be sent as a chat message. The same
+// rules are applied to the messages received from the others.
+func Validate(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrEmpty
+	}
+
+	if len(text) > MaxLength {
+		return ErrTooLong
+	}
+
+	if !utf8.ValidString(text) {
+		return ErrInvalid
+	}
+
+	return nil
+}
+
+// Deliver sends the message to ch without blocking. Chat is not worth
+// stalling a protocol for, so if nobody reads the messages, they are
+// dropped.
+func Deliver(ch chan<- Message, msg Message) {
+	select {
+	case ch <- msg:
+	default:
+		log.Warn().
+			Str("from", msg.From.Pretty()).
+			Str("scope", msg.Scope.String()).
+			Msg("Chat message dropped, nobody is reading")
+	}
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Each peer may send a burst of Burst messages, after that one message
+// every RefillEvery.
+var (
+	Burst       = 5
+	RefillEvery = 2 * time.Second
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a per-peer token bucket rate limiter.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[peer.ID]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets: make(map[peer.ID]*bucket),
+	}
+}
+
+// Allow reports whether p may send one more message right now.
+func (l *Limiter) Allow(p peer.ID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	b, exists := l.buckets[p]
+	if !exists {
+		b = &bucket{tokens: float64(Burst), last: now}
+		l.buckets[p] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(RefillEvery)
+	if b.tokens > float64(Burst) {
+		b.tokens = float64(Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/rs/zerolog/log"
+)
+
+const LobbyTopic = "snake_chat"
+
+// LobbyService is a chat for everybody in the pub/sub network.
+type LobbyService struct {
+	self    peer.ID
+	topic   *pubsub.Topic
+	sub     *pubsub.Subscription
+	limiter *Limiter
+	cancel  context.CancelFunc
+
+	out chan<- Message
+}
+
+func NewLobbyService(ps *pubsub.PubSub, self peer.ID, out chan<- Message) (*LobbyService, error) {
+	// Oversized messages are not even relayed further
+	err := ps.RegisterTopicValidator(LobbyTopic, func(_ context.Context, _ peer.ID, msg *pubsub.Message) bool {
+		return Validate(string(msg.Data)) == nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("register chat validator: %v", err)
+	}
+
+	topic, err := ps.Join(LobbyTopic)
+	if err != nil {
+		return nil, fmt.Errorf("join chat topic: %v", err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to chat topic: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &LobbyService{
+		self:    self,
+		topic:   topic,
+		sub:     sub,
+		limiter: NewLimiter(),
+		cancel:  cancel,
+
+		out: out,
+	}
+
+	go s.readLoop(ctx)
+
+	return s, nil
+}
+
+func (s *LobbyService) readLoop(ctx context.Context) {
+	for {
+		psMsg, err := s.sub.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Err(err).Msg("Receive lobby chat message")
+			}
+			return
+		}
+
+		from := psMsg.GetFrom()
+		if from == s.self {
+			continue
+		}
+
+		if !s.limiter.Allow(from) {
+			log.Warn().Str("from", from.Pretty()).Msg("Lobby chat message rate limited")
+			continue
+		}
+
+		Deliver(s.out, Message{
+			Scope: Lobby,
+			From:  from,
+			Text:  string(psMsg.Data),
+		})
+	}
+}
+
+// Send publishes the text to the lobby. The message is delivered to
+// ourselves too.
+func (s *LobbyService) Send(ctx context.Context, text string) error {
+	if err := Validate(text); err != nil {
+		return err
+	}
+
+	if !s.limiter.Allow(s.self) {
+		return ErrRateLimited
+	}
+
+	err := s.topic.Publish(ctx, []byte(text))
+	if err != nil {
+		return fmt.Errorf("publish chat message: %v", err)
+	}
+
+	Deliver(s.out, Message{
+		Scope: Lobby,
+		From:  s.self,
+		Text:  text,
+	})
+
+	return nil
+}
+
+func (s *LobbyService) Close() {
+	s.cancel()
+	s.sub.Cancel()
+
+	err := s.topic.Close()
+	if err != nil {
+		log.Err(err).Msg("Close chat topic")
+	}
+}
@@ -0,0 +1,134 @@
+package game
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// moveDigest is the digest of a single move of a completed tick. After
+// completing a tick, every peer echoes the digests to everyone else, so
+// that a player who sent different moves to different peers is noticed
+// even if nobody voted.
+type moveDigest struct {
+	ID     peer.ID
+	Digest []byte
+	Missed bool `json:",omitempty"`
+}
+
+func digestOf(tick int, m playerMove) []byte {
+	sum := sha256.Sum256(m.content(tick))
+	return sum[:]
+}
+
+// echo sends everyone the digests of the moves the tick was completed
+// with, and checks the echoes that came before we completed it. Must be
+// called with the lock held.
+func (gi *GameInstance) echo(record tickRecord) {
+	tick := record.Moves.Tick
+
+	var digests []moveDigest
+	for id, m := range record.By {
+		digests = append(digests, moveDigest{
+			ID:     id,
+			Digest: digestOf(tick, m),
+			Missed: m.Missed,
+		})
+	}
+
+	gi.send(GameMessage{
+		Type:    Echo,
+		Tick:    tick,
+		Digests: digests,
+	})
+
+	for from, theirs := range gi.echoes[tick] {
+		gi.compare(from, tick, record, theirs)
+	}
+	delete(gi.echoes, tick)
+}
+
+func (gi *GameInstance) receiveEcho(from peer.ID, tick int, digests []moveDigest) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if _, playing := gi.players[from]; !playing {
+		return
+	}
+
+	if tick < 0 || tick >= gi.next+maxAhead {
+		return
+	}
+
+	if tick < gi.next {
+		gi.compare(from, tick, gi.history[tick], digests)
+		return
+	}
+
+	echoes, ok := gi.echoes[tick]
+	if !ok {
+		echoes = make(map[peer.ID][]moveDigest)
+		gi.echoes[tick] = echoes
+	}
+	echoes[from] = digests
+}
+
+// compare checks the echoed digests against our record of the tick. If
+// the player's move differs, we send the echoer the move we have. Along
+// with theirs it proves the equivocation, refer to witness. The game
+// stops either way. The moves of the eliminated players are not compared,
+// they no longer count. Must be called with the lock held.
+func (gi *GameInstance) compare(from peer.ID, tick int, record tickRecord, theirs []moveDigest) {
+	for _, d := range theirs {
+		m, exists := record.By[d.ID]
+		if !exists || gi.isEliminated(d.ID, tick) {
+			continue
+		}
+
+		if m.Missed == d.Missed && bytes.Equal(digestOf(tick, m), d.Digest) {
+			continue
+		}
+
+		if m.Missed || d.Missed {
+			log.Error().
+				Str("player", d.ID.Pretty()).
+				Str("echoer", from.Pretty()).
+				Int("tick", tick).
+				Msg("Peers disagree whether the player has moved")
+			gi.halt()
+			return
+		}
+
+		if s, exists := gi.streams[from]; exists {
+			err := gi.write(s, GameMessage{
+				Type:  Vote,
+				Tick:  tick,
+				Moves: []playerMove{m},
+			})
+			if err != nil {
+				log.Err(err).Str("player", from.Pretty()).Msg("Send disputed move")
+			}
+		}
+
+		gi.flag(d.ID, tick)
+		gi.halt()
+		return
+	}
+}
+
+// halt stops the game, the peers no longer agree on what happened. Must be
+// called with the lock held.
+func (gi *GameInstance) halt() {
+	if gi.halted {
+		return
+	}
+	gi.halted = true
+
+	log.Error().Int("tick", gi.next).Msg("Game halted")
+
+	gi.events = append(gi.events, core.GameOver{Successful: false, Stats: gi.stats()})
+	gi.wake()
+}
@@ -0,0 +1,205 @@
+package game
+
+import (
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// Propose asks the other players to evict the player, for example for
+// cheating. The proposal and its cause are sent along with our moves, and
+// the player is evicted once the majority of the remaining players propose
+// the same in one tick.
+func (gi *GameInstance) Propose(p peer.ID, cause core.DeathCause) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	gi.propose(p, cause)
+}
+
+// propose is Propose that must be called with the lock held.
+func (gi *GameInstance) propose(p peer.ID, cause core.DeathCause) {
+	if _, playing := gi.players[p]; !playing {
+		return
+	}
+
+	if _, exists := gi.proposals[p]; exists {
+		return
+	}
+
+	log.Warn().
+		Str("player", p.Pretty()).
+		Stringer("cause", cause).
+		Msg("Propose to evict player")
+
+	gi.proposals[p] = cause
+}
+
+// proposed lists our eviction proposals and their causes. Must be called
+// with the lock held.
+func (gi *GameInstance) proposed() ([]peer.ID, []core.DeathCause) {
+	var ids []peer.ID
+	for id := range gi.proposals {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	causes := make([]core.DeathCause, len(ids))
+	for i, id := range ids {
+		causes[i] = gi.proposals[id]
+	}
+
+	return ids, causes
+}
+
+// judge plays the completed tick by the rules, the players whose snakes
+// die in it are eliminated from the next tick on. The record is the same
+// on every peer, so they all eliminate the player at the same tick, before
+// completing the next one, no matter how far behind their UI is. The
+// player is not waited for after that, but stays connected. Must be called
+// with the lock held.
+func (gi *GameInstance) judge(record tickRecord) {
+	if gi.world == nil {
+		return
+	}
+
+	tick := record.Moves.Tick
+	for _, p := range gi.world.Step(record.Moves.Moves) {
+		log.Info().
+			Str("player", p.Pretty()).
+			Int("tick", tick).
+			Msg("Player eliminated")
+
+		gi.eliminated[p] = tick + 1
+	}
+	gi.score()
+}
+
+// played is the tick as the rules have played it, the evictions of the
+// tick included. Must be called with the lock held.
+func (gi *GameInstance) played(record tickRecord) TickPlayed {
+	played := TickPlayed{PlayerMoves: record.Moves}
+	if gi.world != nil {
+		played.World = gi.world.Snapshot()
+		played.Events = gi.world.Events
+	}
+
+	return played
+}
+
+// score counts the round in the standings of a room, once the rules have
+// ended it. Must be called with the lock held.
+func (gi *GameInstance) score() {
+	if gi.scored || gi.world == nil || !gi.world.Over || !gi.settings.Room() {
+		return
+	}
+	gi.scored = true
+
+	gi.standings.Add(gi.world)
+}
+
+// tally evicts the players that the majority of the remaining active
+// players have proposed to evict in the tick. Must be called with the
+// lock held.
+func (gi *GameInstance) tally(record tickRecord) {
+	votes := make(map[peer.ID]int)
+	causes := make(map[peer.ID]map[core.DeathCause]int)
+	for voter, m := range record.By {
+		if m.Missed {
+			continue
+		}
+
+		for i, p := range m.Evict {
+			if p == voter {
+				continue
+			}
+
+			votes[p]++
+			if causes[p] == nil {
+				causes[p] = make(map[core.DeathCause]int)
+			}
+			cause := core.Evicted
+			if i < len(m.Causes) {
+				cause = m.Causes[i]
+			}
+			causes[p][cause]++
+		}
+	}
+
+	ids := make([]peer.ID, 0, len(votes))
+	for p := range votes {
+		ids = append(ids, p)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	for _, p := range ids {
+		if _, playing := gi.players[p]; !playing && p != gi.selfID {
+			continue
+		}
+
+		remaining := 0
+		for id := range record.Moves.Moves {
+			if id != p && !gi.isEliminated(id, record.Moves.Tick) {
+				remaining++
+			}
+		}
+
+		if votes[p]*2 > remaining {
+			gi.evict(p, mostVoted(causes[p]))
+		}
+	}
+}
+
+// mostVoted is the cause most voters have given, the ties go to the lowest
+// one. The votes are in the agreed record, so every peer picks the same.
+func mostVoted(causes map[core.DeathCause]int) core.DeathCause {
+	best, most := core.Evicted, 0
+	for cause, n := range causes {
+		if n > most || (n == most && cause < best) {
+			best, most = cause, n
+		}
+	}
+
+	return best
+}
+
+// evict removes the player from the game. The death is among the events
+// of the tick, refer to played. Must be called with the lock held.
+func (gi *GameInstance) evict(p peer.ID, cause core.DeathCause) {
+	log.Warn().
+		Str("player", p.Pretty()).
+		Int("tick", gi.next).
+		Msg("Evict player")
+
+	if gi.world != nil {
+		gi.world.Kill(p, cause)
+		gi.score()
+	}
+
+	if p == gi.selfID {
+		return
+	}
+
+	delete(gi.players, p)
+	delete(gi.missed, p)
+	delete(gi.proposals, p)
+	delete(gi.eliminated, p)
+	if t, away := gi.away[p]; away {
+		t.Stop()
+		delete(gi.away, p)
+	}
+
+	if s, exists := gi.streams[p]; exists {
+		err := s.Close()
+		if err != nil {
+			log.Err(err).Msg("Close evicted player's stream")
+		}
+		delete(gi.streams, p)
+	}
+}
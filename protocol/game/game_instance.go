@@ -1,64 +1,226 @@
 package game
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"math/rand"
 	"sort"
-	"strconv"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/engine/rules"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
+	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/rs/zerolog/log"
 )
 
 type GameEstablished struct {
+	ID          GameID
 	Facilitator peer.ID
 	Game        *GameInstance
+	Settings    core.GameSettings
 }
 
+// playerMove is a move of a single player, it is also the element of
+// the Replay history and of the votes on the wire. Missed marks a move
+// substituted with the player's previous direction. Evict lists the
+// players the mover wants out of the game, and Causes why, one for each.
+// Prev and Sig are described in MoveLog.
 type playerMove struct {
-	ID  peer.ID
-	Dir core.Direction
+	ID     peer.ID
+	Dir    core.Direction
+	Missed bool              `json:",omitempty"`
+	Evict  []peer.ID         `json:",omitempty"`
+	Causes []core.DeathCause `json:",omitempty"`
+	Prev   []byte            `json:",omitempty"`
+	Sig    []byte            `json:",omitempty"`
+}
+
+type seedPiece struct {
+	ID    peer.ID
+	Piece uint32
 }
 
 type GameInstanceEvent interface{}
 
-type GameInstance struct {
-	done       chan struct{}
-	finishedCh chan struct{}
-	streams    map[peer.ID]network.Stream
-	selfID     peer.ID
-	Seed       int64
+// Dialer opens a new game stream to the player.
+type Dialer func(ctx context.Context, p peer.ID) (network.Stream, error)
 
-	recv  chan interface{}
-	moves chan playerMove
+type GameInstance struct {
+	done    chan struct{}
+	over    chan struct{} // closed when the round ends, refer to Rematch
+	wg      sync.WaitGroup
+	streams map[peer.ID]network.Stream
+	selfID  peer.ID
+	Seed    int64
+	dial    Dialer
+	ping    Pinger
+
+	// Our key signs the moves, the players' keys verify them.
+	key     crypto.PrivKey
+	keys    map[peer.ID]crypto.PubKey
+	head    []byte
+	hashes  map[string]bool
+	flagged map[peer.ID]bool
+
+	facilitator peer.ID
+	settings    core.GameSettings
+	started     bool
+	round       int
+	teams       map[peer.ID]int
+	readyCh     chan peer.ID
+	startCh     chan GameMessage
+	seedCh      chan seedPiece
+
+	recv chan interface{}
+
+	// chatCh is apart from recv, so that the chat does not wait for the
+	// UI to get to the game.
+	chatCh chan chat.Message
+
+	// The lockstep state, refer to sync.go. The roster of the game is
+	// players, it does not change when a connection breaks.
+	players map[peer.ID]struct{}
+	running bool
+	next    int
+	sent    int
+	pending map[int]map[peer.ID]playerMove
+	history []tickRecord
+	lastDir map[peer.ID]core.Direction
+	missed  map[peer.ID]int
+	votes   map[int]map[peer.ID][]playerMove
+	voted   map[int]bool
+
+	// world plays the completed ticks by the rules to know when the
+	// snakes die, refer to judge. The UI gets its copies.
+	world *rules.World
+
+	// standings are the scores over the rounds of a room, scored tells
+	// whether this round is counted in, refer to score.
+	standings rules.Standings
+	scored    bool
+
+	// The digests of the ticks the others have echoed, refer to echo.go.
+	echoes map[int]map[peer.ID][]moveDigest
+	halted bool
+
+	// The eviction agreement, refer to evict.go.
+	proposals  map[peer.ID]core.DeathCause
+	eliminated map[peer.ID]int
+	events     []interface{}
+	poke       chan struct{}
+
+	// The players that lost the connection and have got a grace window
+	// to come back, refer to reconnect.go.
+	away map[peer.ID]*time.Timer
+
+	// The newcomers of a room that connected during a round, they play
+	// from the next one.
+	newcomers map[peer.ID]network.Stream
+
+	// The round-trip times and the timing picked for them, refer to
+	// latency.go. reports include our own.
+	rtt        map[peer.ID]time.Duration
+	reports    map[peer.ID][]peerRTT
+	rttHistory []time.Duration
+	timing     core.Timing
+
+	chatLimiter *chat.Limiter
 
 	mu sync.Mutex
 }
 
-func NewGameInstance() *GameInstance {
-	return &GameInstance{
-		done:       make(chan struct{}),
-		finishedCh: make(chan struct{}),
-		streams:    make(map[peer.ID]network.Stream),
+func NewGameInstance(self peer.ID, key crypto.PrivKey, dial Dialer, ping Pinger) *GameInstance {
+	gi := &GameInstance{
+		selfID: self,
+		key:    key,
+		dial:   dial,
+		ping:   ping,
+
+		// Buffered, so that the messages of the next phase can arrive
+		// while we are still finishing the current one.
+		readyCh: make(chan peer.ID, 32),
+		startCh: make(chan GameMessage, 1),
+		seedCh:  make(chan seedPiece, 32),
+
+		recv:   make(chan interface{}),
+		chatCh: make(chan chat.Message, 32),
+		poke:   make(chan struct{}, 1),
+
+		chatLimiter: chat.NewLimiter(),
+	}
+
+	gi.reset()
+
+	return gi
+}
+
+// reset brings the instance to the state before the gathering.
+func (gi *GameInstance) reset() {
+	gi.done = make(chan struct{})
+	gi.streams = make(map[peer.ID]network.Stream)
+	gi.facilitator = ""
+	gi.started = false
+	gi.round = 0
+
+	gi.players = make(map[peer.ID]struct{})
+	gi.newcomers = make(map[peer.ID]network.Stream)
+	gi.standings = make(rules.Standings)
 
-		recv: make(chan interface{}),
+	gi.rtt = make(map[peer.ID]time.Duration)
+	gi.reports = make(map[peer.ID][]peerRTT)
+	gi.rttHistory = nil
 
-		// FIXME: if SendEvent and we form the move, then we need the user to
-		// receive the PlayerMoves event
-		// but if it waits send event to finishi... it just deadlocks.
-		moves: make(chan playerMove, 32),
+	gi.keys = map[peer.ID]crypto.PubKey{
+		gi.selfID: gi.key.GetPublic(),
 	}
+
+	gi.resetRound()
+}
+
+// resetRound brings the instance to the state before the ready check,
+// the connections to the players stay. Must be called with the lock held
+// once the instance is in use.
+func (gi *GameInstance) resetRound() {
+	gi.over = make(chan struct{})
+
+	gi.running = false
+	gi.next = 0
+	gi.sent = 0
+	gi.pending = make(map[int]map[peer.ID]playerMove)
+	gi.history = nil
+	gi.lastDir = make(map[peer.ID]core.Direction)
+	gi.missed = make(map[peer.ID]int)
+	gi.votes = make(map[int]map[peer.ID][]playerMove)
+	gi.voted = make(map[int]bool)
+	gi.world = nil
+	gi.scored = false
+	gi.echoes = make(map[int]map[peer.ID][]moveDigest)
+	gi.halted = false
+	gi.proposals = make(map[peer.ID]core.DeathCause)
+	gi.eliminated = make(map[peer.ID]int)
+	gi.events = nil
+
+	gi.away = make(map[peer.ID]*time.Timer)
+
+	gi.timing = core.Timing{}
+	gi.teams = nil
+
+	gi.head = nil
+	gi.hashes = map[string]bool{"": true}
+	gi.flagged = make(map[peer.ID]bool)
 }
 
+// PlayersIDs returns the sorted roster of the game including ourselves.
 func (gi *GameInstance) PlayersIDs() []peer.ID {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 	var playerIDs []peer.ID
-	for id := range gi.streams {
+	for id := range gi.players {
 		playerIDs = append(playerIDs, id)
 	}
 	playerIDs = append(playerIDs, gi.selfID)
@@ -78,18 +240,62 @@ func (gi *GameInstance) IncommingMoves() <-chan interface{} {
 	return gi.recv
 }
 
+// Chat returns the chat messages of the other players. The ones nobody
+// reads in time are dropped.
+func (gi *GameInstance) Chat() <-chan chat.Message {
+	return gi.chatCh
+}
+
+// AddPeer adds the game stream of the player. During the game only the
+// players from the roster are accepted, as they reconnect.
 func (gi *GameInstance) AddPeer(s network.Stream) {
 	p := s.Conn().RemotePeer()
 
 	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	// The secure channel has checked that the key belongs to the peer.
+	gi.keys[p] = s.Conn().RemotePublicKey()
+
+	if gi.running {
+		if _, playing := gi.players[p]; !playing && gi.settings.Room() {
+			log.Info().Str("peer", p.Pretty()).Msg("Newcomer waits for the next round")
+			gi.newcomers[p] = s
+			return
+		}
+
+		gi.resume(s)
+		return
+	}
+
+	gi.join(s)
+}
+
+// join adds the player to the roster. Must be called with the lock held.
+func (gi *GameInstance) join(s network.Stream) {
+	p := s.Conn().RemotePeer()
 	gi.streams[p] = s
-	gi.mu.Unlock()
+	gi.players[p] = struct{}{}
+
+	if gi.started {
+		gi.spawn(func() { gi.readLoop(s) })
+	}
 }
 
+// RemovePeer closes the stream of the player and removes them from the
+// game. During the game, use Propose or Eliminate instead, so that all
+// the players agree on the roster.
 func (gi *GameInstance) RemovePeer(p peer.ID) {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 
+	delete(gi.players, p)
+	if t, away := gi.away[p]; away {
+		t.Stop()
+		delete(gi.away, p)
+	}
+	gi.wake()
+
 	s, exists := gi.streams[p]
 	if !exists {
 		return
@@ -103,10 +309,115 @@ func (gi *GameInstance) RemovePeer(p peer.ID) {
 	delete(gi.streams, p)
 }
 
-func (gi *GameInstance) Close() {
+// Rematch ends the game but keeps the connections to the players, so that
+// the ones who want to play again go through the ready check once more.
+// The players who leave instead drop out as their streams close.
+func (gi *GameInstance) Rematch() {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	close(gi.over)
+
+	for p, t := range gi.away {
+		t.Stop()
+		delete(gi.players, p)
+	}
+
+	// The ready confirmations are kept, they can be for the new round
+	// already.
+	for drained := false; !drained; {
+		select {
+		case <-gi.startCh:
+		case <-gi.seedCh:
+		default:
+			drained = true
+		}
+	}
+
+	gi.round++
+	gi.resetRound()
+
+	for p, s := range gi.newcomers {
+		gi.join(s)
+		delete(gi.newcomers, p)
+	}
+
+	log.Info().Int("round", gi.round).Msg("Rematch")
+}
+
+// Round is the number of the rematches played so far.
+func (gi *GameInstance) Round() int {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	return gi.round
+}
+
+// SetRound lets a newcomer of a room start at the round the others are
+// in. It must be called before the instance is started.
+func (gi *GameInstance) SetRound(round int) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	gi.round = round
+}
+
+// Standings returns a copy of the standings of the room. Every peer counts
+// the same rounds in, the ones the rules have ended.
+func (gi *GameInstance) Standings() rules.Standings {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	return gi.standings.Copy()
+}
+
+// SetStandings lets a newcomer of a room start with the standings the
+// others have. It must be called before the instance is started.
+func (gi *GameInstance) SetStandings(standings rules.Standings) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	gi.standings = standings.Copy()
+}
+
+// World returns a copy of the world of the round as the completed ticks
+// have left it. It is valid once Run has returned.
+func (gi *GameInstance) World() rules.Snapshot {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	return gi.world.Snapshot()
+}
+
+// Stats returns what the players have done in the round so far, nil
+// before Run.
+func (gi *GameInstance) Stats() []core.PlayerStats {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 
+	return gi.stats()
+}
+
+// stats is Stats that must be called with the lock held.
+func (gi *GameInstance) stats() []core.PlayerStats {
+	if gi.world == nil {
+		return nil
+	}
+
+	return gi.world.Stats()
+}
+
+// Running tells whether a round is being played, as opposed to the time
+// before the ready check is over.
+func (gi *GameInstance) Running() bool {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	return gi.running
+}
+
+func (gi *GameInstance) Close() {
+	gi.mu.Lock()
 	for p, s := range gi.streams {
 		err := s.Close()
 		if err != nil {
@@ -116,13 +427,50 @@ func (gi *GameInstance) Close() {
 		}
 	}
 
+	for _, t := range gi.away {
+		t.Stop()
+	}
+
+	for _, s := range gi.newcomers {
+		s.Reset()
+	}
+
+	gi.running = false
 	close(gi.done)
-	<-gi.finishedCh
-	for range gi.streams {
-		<-gi.finishedCh
+	gi.mu.Unlock()
+
+	// Do not hold the lock, the goroutines need it to finish.
+	gi.wg.Wait()
+
+	gi.mu.Lock()
+	gi.reset()
+	gi.mu.Unlock()
+}
+
+// spawn runs f in a goroutine that Close waits for. Must be called with
+// the lock held.
+func (gi *GameInstance) spawn(f func()) {
+	select {
+	case <-gi.done:
+		return
+	default:
 	}
 
-	gi.done = make(chan struct{})
+	gi.wg.Add(1)
+	go func() {
+		defer gi.wg.Done()
+		f()
+	}()
+}
+
+// emit delivers the event to the UI unless the instance is closed.
+func (gi *GameInstance) emit(e interface{}) bool {
+	select {
+	case gi.recv <- e:
+		return true
+	case <-gi.done:
+		return false
+	}
 }
 
 func (gi *GameInstance) PeerCount() int {
@@ -133,96 +481,172 @@ func (gi *GameInstance) PeerCount() int {
 	return n
 }
 
-func (gi *GameInstance) Run() int64 {
-	gi.Seed = int64(gi.negotiateSeed())
-
+// Start begins to receive messages from the other players and to
+// measure the latency to them. The facilitator is the one who conducts
+// the ready check and picks the timing.
+func (gi *GameInstance) Start(facilitator peer.ID, settings core.GameSettings) {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 
+	gi.facilitator = facilitator
+	gi.settings = settings
+	gi.timing = pace(0, settings.TickRate)
+
+	// A rematch goes on with the same connections.
+	if gi.started {
+		return
+	}
+	gi.started = true
+
 	for _, s := range gi.streams {
-		if gi.selfID == "" {
-			gi.selfID = s.Conn().LocalPeer()
-		}
+		s := s
+		gi.spawn(func() { gi.readLoop(s) })
+	}
 
-		go gi.readLoop(s)
+	if gi.ping != nil {
+		gi.spawn(gi.pingLoop)
 	}
+}
+
+// Run negotiates the random seed with the players that passed the ready
+// check and starts the game.
+func (gi *GameInstance) Run() int64 {
+	gi.Seed = int64(gi.negotiateSeed())
 
-	go gi.syncLoop()
+	gi.mu.Lock()
+	// The map is checked before the ready check.
+	board, _ := core.Maps.Get(gi.settings.Map)
+	gi.world = rules.NewWorld(gi.settings, board, gi.roster(), gi.teams, gi.Seed)
+
+	gi.running = true
+	over := gi.over
+	gi.spawn(func() { gi.syncLoop(over) })
+	gi.mu.Unlock()
 
 	return gi.Seed
 }
 
-func ToBytesUint32(n uint32) []byte {
-	b := make([]byte, 4)
-	for i := 0; n != 0 && i < 4; i++ {
-		b[i] = byte(n & 0xFF)
-		n >>= 8
-	}
+func (gi *GameInstance) negotiateSeed() uint32 {
+	// TODO: commit-reveal, the last one to send its piece
+	// can choose the seed.
+	my := rand.Uint32()
 
-	return b
-}
+	gi.broadcast(GameMessage{
+		Type: Seed,
+		Seed: my,
+	})
+
+	seed := my
+	received := make(map[peer.ID]struct{})
+	timer := time.NewTimer(ReadyTimeout)
+	defer timer.Stop()
 
-func ToUint32Bytes(b []byte) (n uint32) {
-	for k := 0; k < 4; k++ {
-		// TODO: precedance?
-		n |= uint32(b[k]) << (8 * k)
+	for len(received) < gi.PeerCount() {
+		select {
+		case piece := <-gi.seedCh:
+			if _, dup := received[piece.ID]; dup {
+				continue
+			}
+			received[piece.ID] = struct{}{}
+
+			log.Debug().
+				Str("peer", piece.ID.Pretty()).
+				Uint32("piece", piece.Piece).
+				Msg("Receive other random piece")
+
+			seed ^= piece.Piece
+		case <-timer.C:
+			log.Error().
+				Int("received", len(received)).
+				Int("expected", gi.PeerCount()).
+				Msg("Timed out waiting for random pieces")
+			return seed
+		}
 	}
 
-	return
+	log.Info().Uint32("seed", seed).Msg("Negotiated random seed")
+
+	return seed
 }
 
-func (gi *GameInstance) negotiateSeed() uint32 {
+// broadcast sends the message to every player, the failures are only
+// logged.
+func (gi *GameInstance) broadcast(msg GameMessage) {
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 
-	// TODO: TextMarshaller interface
-	my := rand.Uint32()
-	myBytes := ToBytesUint32(my)
+	gi.send(msg)
+}
 
-	for peer, s := range gi.streams {
-		_, err := s.Write(myBytes)
+// send is broadcast that must be called with the lock held.
+func (gi *GameInstance) send(msg GameMessage) {
+	for p, s := range gi.streams {
+		err := gi.write(s, msg)
 		if err != nil {
 			log.Err(err).
-				Str("peer", peer.Pretty()).
-				Msg("Send our random piece")
+				Str("peer", p.Pretty()).
+				Int("type", int(msg.Type)).
+				Msg("Send game message")
 		}
 	}
+}
 
-	otherBytes := make([]byte, 4)
-	for peer, s := range gi.streams {
-		_, err := s.Read(otherBytes)
-		if err != nil {
-			log.Err(err).
-				Str("peer", peer.Pretty()).
-				Msg("Receive other random piece")
-			continue
-		}
+// SendMove sends our move for the next tick we have not moved in yet, and
+// returns that tick.
+func (gi *GameInstance) SendMove(move core.Direction) (tick int, err error) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	tick = gi.sent
+	if tick < gi.next {
+		tick = gi.next
+	}
+	gi.sent = tick + 1
 
-		log.Debug().
-			Str("peer", peer.Pretty()).
-			Uint32("piece", ToUint32Bytes(otherBytes)).
-			Msg("Receive other random piece")
+	evict, causes := gi.proposed()
+	m, err := gi.sign(tick, playerMove{Dir: move, Evict: evict, Causes: causes})
+	if err != nil {
+		return tick, err
+	}
 
-		for i, other := range otherBytes {
-			myBytes[i] ^= other
+	msg := moveMessage(tick, m)
+
+	for p, s := range gi.streams {
+		streamErr := gi.write(s, msg)
+		if streamErr != nil {
+			err = multierror.Append(err, &core.PeerError{
+				Peer: p,
+				Err:  streamErr,
+			})
 		}
 	}
 
-	seed := ToUint32Bytes(myBytes)
-	log.Info().Uint32("seed", seed).Msg("Negotiated random seed")
+	gi.addMove(tick, m)
 
-	return seed
+	return
 }
 
-func (gi *GameInstance) SendMove(move core.Direction) (err error) {
+// SendChat sends the text to all players. Incoming chat messages are
+// delivered through Chat.
+func (gi *GameInstance) SendChat(text string) (err error) {
+	if err := chat.Validate(text); err != nil {
+		return err
+	}
+
 	gi.mu.Lock()
 	defer gi.mu.Unlock()
 
-	// TODO: have a sane protocol, not just numbers flying around...
-	msg := strconv.Itoa(int(move)) + "\n"
+	if !gi.chatLimiter.Allow(gi.selfID) {
+		return chat.ErrRateLimited
+	}
+
+	msg := GameMessage{
+		Type: Chat,
+		Text: text,
+	}
 
 	for p, s := range gi.streams {
-		_, streamErr := s.Write([]byte(msg))
+		streamErr := gi.write(s, msg)
 		if streamErr != nil {
 			err = multierror.Append(err, &core.PeerError{
 				Peer: p,
@@ -231,18 +655,13 @@ func (gi *GameInstance) SendMove(move core.Direction) (err error) {
 		}
 	}
 
-	gi.moves <- playerMove{
-		ID:  gi.selfID,
-		Dir: move,
-	}
-
 	return
 }
 
 func (gi *GameInstance) readLoop(stream network.Stream) {
 	remotePeer := stream.Conn().RemotePeer()
 
-	scanner := bufio.NewScanner(stream)
+	scanner := newScanner(stream)
 	readCh := make(chan bool)
 	defer close(readCh)
 
@@ -252,9 +671,6 @@ func (gi *GameInstance) readLoop(stream network.Stream) {
 
 	go scan()
 
-	// XXX: refer to JoinService
-	reading := true
-
 	for {
 		select {
 		case <-gi.done:
@@ -265,15 +681,10 @@ func (gi *GameInstance) readLoop(stream network.Stream) {
 					Msg("Close game stream due to Close()")
 			}
 
-			if reading {
-				<-readCh // When stream has closed, .Scan() should quit
-			}
-
-			gi.finishedCh <- struct{}{}
+			<-readCh // When stream has closed, .Scan() should quit
 			return
 		case ok := <-readCh:
 			if !ok {
-				reading = false
 				err := stream.Close()
 				if err != nil {
 					log.Err(err).
@@ -281,60 +692,107 @@ func (gi *GameInstance) readLoop(stream network.Stream) {
 						Msg("Close game stream")
 				}
 
-				gi.RemovePeer(remotePeer)
-
-				// XXX: hax number 1000
-				gi.moves <- playerMove{}
-
-				log.Error().Msg("Dead")
-
-				gi.recv <- remotePeer
-
-				// Do not scan() again
-				continue
+				gi.lose(remotePeer, stream)
+				return
 			}
 
-			n, err := strconv.Atoi(scanner.Text())
+			var msg GameMessage
+			err := json.Unmarshal(scanner.Bytes(), &msg)
 			if err != nil {
 				log.Err(err).
 					Str("player", remotePeer.Pretty()).
-					Msg("Parse player move")
+					Msg("Parse game message")
 
 				go scan()
 				continue
 			}
 
-			dir := core.Direction(n)
+			if !gi.current(msg) {
+				log.Debug().
+					Str("player", remotePeer.Pretty()).
+					Int("type", int(msg.Type)).
+					Int("round", msg.Round).
+					Msg("Drop message of another round")
 
-			gi.moves <- playerMove{
-				ID:  remotePeer,
-				Dir: dir,
+				go scan()
+				continue
 			}
 
-			go scan()
-		}
-	}
-}
-
-func (gi *GameInstance) syncLoop() {
-	msg := core.PlayerMoves{
-		Moves: make(map[peer.ID]core.Direction),
-	}
+			switch msg.Type {
+			case Ready:
+				// Nobody may be reading, and the duplicates are of no use.
+				select {
+				case gi.readyCh <- remotePeer:
+				default:
+					log.Warn().
+						Str("player", remotePeer.Pretty()).
+						Msg("Drop ready message, too many pending")
+				}
+			case Start:
+				if remotePeer != gi.facilitator {
+					log.Warn().
+						Str("player", remotePeer.Pretty()).
+						Msg("Start message not from the facilitator")
+					break
+				}
 
-	for peerMove := range gi.moves {
-		if peerMove.ID != "" {
-			log.Debug().Str("peer", peerMove.ID.Pretty()).Int("dir", int(peerMove.Dir)).Msg("Received move")
-			msg.Moves[peerMove.ID] = peerMove.Dir
-		} else {
-			log.Debug().Msg("stub player move received to recheck peer count condition")
-		}
+				select {
+				case gi.startCh <- msg:
+				default:
+					log.Warn().Msg("Duplicate start message")
+				}
+			case Seed:
+				select {
+				case gi.seedCh <- seedPiece{ID: remotePeer, Piece: msg.Seed}:
+				default:
+					log.Warn().
+						Str("player", remotePeer.Pretty()).
+						Msg("Drop seed message, too many pending")
+				}
+			case Move:
+				gi.receiveMove(msg.Tick, msg.move(remotePeer))
+			case Resume:
+				gi.sendReplay(remotePeer, msg.Tick)
+			case Replay:
+				gi.applyReplay(remotePeer, msg.Tick, msg.History)
+			case Vote:
+				gi.receiveVote(remotePeer, msg.Tick, msg.Moves, msg.Answer)
+			case Equivocation:
+				gi.receiveEquivocation(remotePeer, msg.Tick, msg.Moves)
+			case Echo:
+				gi.receiveEcho(remotePeer, msg.Tick, msg.Digests)
+			case Latency:
+				gi.receiveLatency(remotePeer, msg.RTT)
+			case Tune:
+				gi.receiveTune(remotePeer, msg.timing())
+			case Chat:
+				if err := chat.Validate(msg.Text); err != nil {
+					log.Warn().Err(err).
+						Str("player", remotePeer.Pretty()).
+						Msg("Received invalid chat message")
+					break
+				}
 
-		if len(msg.Moves) == gi.PeerCount()+1 {
-			gi.recv <- msg
+				if !gi.chatLimiter.Allow(remotePeer) {
+					log.Warn().
+						Str("player", remotePeer.Pretty()).
+						Msg("Game chat message rate limited")
+					break
+				}
 
-			msg = core.PlayerMoves{
-				Moves: make(map[peer.ID]core.Direction),
+				chat.Deliver(gi.chatCh, chat.Message{
+					Scope: chat.Game,
+					From:  remotePeer,
+					Text:  msg.Text,
+				})
+			default:
+				log.Warn().
+					Str("player", remotePeer.Pretty()).
+					Int("type", int(msg.Type)).
+					Msg("Game message of unknown type")
 			}
+
+			go scan()
 		}
 	}
 }
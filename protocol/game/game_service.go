@@ -1,26 +1,53 @@
 package game
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/rs/zerolog/log"
 )
 
-const ID = "/snake/game/0.1.0"
+const ID = "/snake/game/0.3.0"
+
+// HandshakeTimeout is how long the game stream has to name its game.
+var HandshakeTimeout = 5 * time.Second
+
+// maxGameIDLen bounds the handshake line.
+const maxGameIDLen = 64
+
+var ErrUnknownGame = errors.New("unknown game")
+
+// GameID tells apart the games a node takes part in. It is chosen by the
+// facilitator, told to the seekers when they are let in, and agreed on in
+// GatheringFinished. Every game stream starts with the ID on a line of
+// its own, so that it is routed to the right instance.
+type GameID string
 
 type GameService struct {
-	h        host.Host
-	instance *GameInstance
+	h    host.Host
+	ping Pinger
+
+	mu        sync.Mutex
+	instances map[GameID]*GameInstance
 }
 
-func NewGameService(h host.Host) *GameService {
+func NewGameService(h host.Host, ping *ping.PingService) *GameService {
 	game := &GameService{
-		h:        h,
-		instance: NewGameInstance(),
+		h:         h,
+		instances: make(map[GameID]*GameInstance),
+	}
+	if ping != nil {
+		game.ping = ping.Ping
 	}
 
 	h.SetStreamHandler(ID, game.GameHandler)
@@ -28,40 +55,165 @@ func NewGameService(h host.Host) *GameService {
 	return game
 }
 
-func (g *GameService) Connect(ctx context.Context, p peer.ID) error {
+// NewGame creates the instance of a game with a fresh ID.
+func (g *GameService) NewGame() (GameID, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate game ID: %v", err)
+	}
+
+	id := GameID(hex.EncodeToString(raw))
+	g.Instance(id)
+
+	return id, nil
+}
+
+// Instance returns the instance of the game, it is created if the game
+// is new to us.
+func (g *GameService) Instance(id GameID) *GameInstance {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	gi, exists := g.instances[id]
+	if !exists {
+		dial := func(ctx context.Context, p peer.ID) (network.Stream, error) {
+			return g.dial(ctx, id, p)
+		}
+
+		gi = NewGameInstance(g.h.ID(), g.h.Peerstore().PrivKey(g.h.ID()), dial, g.ping)
+		g.instances[id] = gi
+	}
+
+	return gi
+}
+
+// lookup is Instance that does not create the game.
+func (g *GameService) lookup(id GameID) (*GameInstance, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	gi, exists := g.instances[id]
+	return gi, exists
+}
+
+// Connect opens the game stream to the player. The game must be known to
+// us and not ended.
+func (g *GameService) Connect(ctx context.Context, id GameID, p peer.ID) error {
+	gi, exists := g.lookup(id)
+	if !exists {
+		return ErrUnknownGame
+	}
+
+	s, err := g.dial(ctx, id, p)
+	if err != nil {
+		return err
+	}
+
+	// The game may have ended while we were dialing.
+	if current, exists := g.lookup(id); !exists || current != gi {
+		s.Reset()
+		return ErrUnknownGame
+	}
+
+	gi.AddPeer(s)
+
+	return nil
+}
+
+func (g *GameService) dial(ctx context.Context, id GameID, p peer.ID) (network.Stream, error) {
 	s, err := g.h.NewStream(ctx, p, ID)
 	if err != nil {
 		// TODO: maybe PeerError? But then how to zerolog?
-		return fmt.Errorf("new game stream: %v", err)
+		return nil, fmt.Errorf("new game stream: %v", err)
 	}
 
-	_, err = s.Write(nil)
+	_, err = s.Write([]byte(string(id) + "\n"))
 	if err != nil {
-		return fmt.Errorf("force new game stream: %v", err)
+		s.Reset()
+		return nil, fmt.Errorf("send game ID: %v", err)
 	}
 
-	g.instance.AddPeer(s)
+	return s, nil
+}
 
-	return nil
+// readGameID reads the handshake line byte by byte, so that nothing
+// after it is buffered away from the instance.
+func readGameID(s network.Stream) (GameID, error) {
+	err := s.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		return "", fmt.Errorf("set deadline: %v", err)
+	}
+	defer s.SetReadDeadline(time.Time{})
+
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for line.Len() <= maxGameIDLen {
+		if _, err := s.Read(b); err != nil {
+			return "", fmt.Errorf("read game ID: %v", err)
+		}
+
+		if b[0] == '\n' {
+			return GameID(line.String()), nil
+		}
+		line.WriteByte(b[0])
+	}
+
+	return "", errors.New("game ID is too long")
 }
 
-func (g *GameService) Disconnect(p peer.ID) {
-	g.instance.RemovePeer(p)
+func (g *GameService) Disconnect(id GameID, p peer.ID) {
+	if gi, exists := g.lookup(id); exists {
+		gi.RemovePeer(p)
+	}
 }
 
 func (g *GameService) GameHandler(s network.Stream) {
 	p := s.Conn().RemotePeer()
+
+	var gi *GameInstance
+	id, err := readGameID(s)
+	if err == nil {
+		var exists bool
+		if gi, exists = g.lookup(id); !exists {
+			err = ErrUnknownGame
+		}
+	}
+	if err != nil {
+		log.Warn().Err(err).
+			Str("peer", p.Pretty()).
+			Msg("Reject game connection")
+		s.Reset()
+		return
+	}
+
 	log.Info().
 		Str("peer", p.Pretty()).
+		Str("game", string(id)).
 		Msg("New incomming game connection")
 
-	g.instance.AddPeer(s)
+	gi.AddPeer(s)
 }
 
-func (g *GameService) Close() {
-	g.instance.Close()
+// End closes the game and forgets it, the streams of the game are no
+// longer accepted.
+func (g *GameService) End(id GameID) {
+	g.mu.Lock()
+	gi, exists := g.instances[id]
+	delete(g.instances, id)
+	g.mu.Unlock()
+
+	if exists {
+		gi.Close()
+	}
 }
 
-func (g *GameService) GetInstance() *GameInstance {
-	return g.instance
+func (g *GameService) Close() {
+	g.mu.Lock()
+	instances := g.instances
+	g.instances = make(map[GameID]*GameInstance)
+	g.mu.Unlock()
+
+	for _, gi := range instances {
+		gi.Close()
+	}
 }
@@ -0,0 +1,277 @@
+package game
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// PingEvery is how often the players measure the round-trip times to
+	// each other.
+	PingEvery = time.Second
+
+	// MaxInputDelay bounds the input delay, the tick rate is lowered
+	// instead when the latency is higher than that.
+	MaxInputDelay = 8
+)
+
+// netHistory is how many of the past measurements NetStats keeps.
+const netHistory = 32
+
+// Pinger measures the round-trip time to the player, refer to
+// ping.PingService.
+type Pinger func(ctx context.Context, p peer.ID) <-chan ping.Result
+
+// peerRTT is the round-trip time to the player as measured by the sender
+// of the Latency message.
+type peerRTT struct {
+	ID  peer.ID
+	RTT time.Duration
+}
+
+// NetStats is what the net graph shows.
+type NetStats struct {
+	RTT     map[peer.ID]time.Duration // our round-trip times
+	Worst   time.Duration             // between any pair of players
+	History []time.Duration           // our worst round-trip times, the oldest first
+	Timing  core.Timing
+}
+
+// NetStats returns the latency measured so far.
+func (gi *GameInstance) NetStats() NetStats {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	rtt := make(map[peer.ID]time.Duration, len(gi.rtt))
+	for id, d := range gi.rtt {
+		rtt[id] = d
+	}
+
+	return NetStats{
+		RTT:     rtt,
+		Worst:   gi.worstRTT(),
+		History: append([]time.Duration(nil), gi.rttHistory...),
+		Timing:  gi.timing,
+	}
+}
+
+// Timing returns the pace of the moves the players have agreed on.
+func (gi *GameInstance) Timing() core.Timing {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	return gi.timing
+}
+
+// pingLoop measures the round-trip times to the players and reports them
+// to everyone. The facilitator adjusts the timing to the reports.
+func (gi *GameInstance) pingLoop() {
+	ticker := time.NewTicker(PingEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gi.done:
+			return
+		case <-ticker.C:
+		}
+
+		gi.measure()
+	}
+}
+
+func (gi *GameInstance) measure() {
+	gi.mu.Lock()
+	ids := make([]peer.ID, 0, len(gi.streams))
+	for id := range gi.streams {
+		ids = append(ids, id)
+	}
+	gi.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PingEvery)
+	defer cancel()
+
+	results := make(chan peerRTT, len(ids))
+	for _, id := range ids {
+		go func(id peer.ID) {
+			res, ok := <-gi.ping(ctx, id)
+			if !ok || res.Error != nil {
+				results <- peerRTT{ID: id}
+				return
+			}
+
+			results <- peerRTT{ID: id, RTT: res.RTT}
+		}(id)
+	}
+
+	measured := make([]peerRTT, 0, len(ids))
+	for range ids {
+		if r := <-results; r.RTT > 0 {
+			measured = append(measured, r)
+		}
+	}
+
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	for _, r := range measured {
+		// Smooth out the jitter, a single slow ping should not change
+		// the timing.
+		if old, known := gi.rtt[r.ID]; known {
+			r.RTT = (old*3 + r.RTT) / 4
+		}
+		gi.rtt[r.ID] = r.RTT
+	}
+
+	report := make([]peerRTT, 0, len(gi.rtt))
+	var worst time.Duration
+	for id, d := range gi.rtt {
+		report = append(report, peerRTT{ID: id, RTT: d})
+		if d > worst {
+			worst = d
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].ID < report[j].ID
+	})
+
+	gi.reports[gi.selfID] = report
+	gi.rttHistory = append(gi.rttHistory, worst)
+	if len(gi.rttHistory) > netHistory {
+		gi.rttHistory = gi.rttHistory[len(gi.rttHistory)-netHistory:]
+	}
+
+	gi.send(GameMessage{
+		Type: Latency,
+		RTT:  report,
+	})
+
+	if gi.running {
+		gi.tune()
+	}
+}
+
+// receiveLatency stores the round-trip times the player has measured.
+func (gi *GameInstance) receiveLatency(from peer.ID, report []peerRTT) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if _, playing := gi.players[from]; !playing {
+		return
+	}
+
+	gi.reports[from] = report
+}
+
+// worstRTT is the highest round-trip time between a pair of players that
+// are still in the game. Must be called with the lock held.
+func (gi *GameInstance) worstRTT() time.Duration {
+	var worst time.Duration
+	for from, report := range gi.reports {
+		if _, playing := gi.players[from]; !playing && from != gi.selfID {
+			continue
+		}
+
+		for _, r := range report {
+			if _, playing := gi.players[r.ID]; !playing && r.ID != gi.selfID {
+				continue
+			}
+			if r.RTT > worst {
+				worst = r.RTT
+			}
+		}
+	}
+
+	return worst
+}
+
+// pace picks the timing the worst pair of players can sustain: a move
+// has to make the round trip before Delay more ticks pass.
+func pace(worst, tickRate time.Duration) core.Timing {
+	delay := int((worst + tickRate - 1) / tickRate)
+	if delay < 1 {
+		delay = 1
+	}
+
+	if delay > MaxInputDelay {
+		delay = MaxInputDelay
+		tickRate = (worst + time.Duration(delay) - 1) / time.Duration(delay)
+	}
+
+	return core.Timing{
+		Delay:    delay,
+		TickRate: tickRate,
+	}
+}
+
+// tune tells the players the new timing if the latency has changed
+// enough. It is only done by the facilitator, if they leave, the timing
+// stays as it is. Must be called with the lock held.
+func (gi *GameInstance) tune() {
+	if gi.facilitator != gi.selfID {
+		return
+	}
+
+	worst := gi.worstRTT()
+	timing := pace(worst, gi.settings.TickRate)
+
+	// Do not flap between two delays when the latency is near the
+	// boundary.
+	if timing.Delay < gi.timing.Delay && pace(worst*5/4, gi.settings.TickRate).Delay >= gi.timing.Delay {
+		return
+	}
+
+	if timing == gi.timing {
+		return
+	}
+
+	log.Info().
+		Dur("worst", worst).
+		Int("delay", timing.Delay).
+		Dur("tick_rate", timing.TickRate).
+		Msg("Tune timing")
+
+	gi.send(GameMessage{
+		Type:     Tune,
+		Delay:    timing.Delay,
+		TickRate: timing.TickRate,
+	})
+	gi.retime(timing)
+}
+
+// receiveTune applies the timing the facilitator has chosen.
+func (gi *GameInstance) receiveTune(from peer.ID, timing core.Timing) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if from != gi.facilitator {
+		log.Warn().
+			Str("player", from.Pretty()).
+			Msg("Tune message not from the facilitator")
+		return
+	}
+
+	if timing.Delay < 1 || timing.TickRate <= 0 {
+		log.Warn().
+			Int("delay", timing.Delay).
+			Dur("tick_rate", timing.TickRate).
+			Msg("Invalid timing")
+		return
+	}
+
+	gi.retime(timing)
+}
+
+// retime switches to the timing and tells the UI. Must be called with the
+// lock held.
+func (gi *GameInstance) retime(timing core.Timing) {
+	gi.timing = timing
+	gi.events = append(gi.events, timing)
+	gi.wake()
+}
@@ -0,0 +1,141 @@
+package game
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+type GameMessageType int
+
+const (
+	Move GameMessageType = iota + 1
+	Chat
+	Ready
+	Start
+	Seed
+	Resume
+	Replay
+	Vote
+	Equivocation
+	Echo
+	Latency
+	Tune
+)
+
+// GameMessage is an all-in-one message of the game protocol, refer to
+// gather.GatherMessage.
+type GameMessage struct {
+	Type   GameMessageType
+	Round  int               `json:",omitempty"` // all but Chat and Latency
+	Dir    core.Direction    // Move
+	Tick   int               `json:",omitempty"` // Move, Resume, Replay, Vote
+	Evict  []peer.ID         `json:",omitempty"` // Move
+	Causes []core.DeathCause `json:",omitempty"` // Move, one for each of Evict
+	Prev   []byte            `json:",omitempty"` // Move
+	Sig    []byte            `json:",omitempty"` // Move
+	Text   string            `json:",omitempty"` // Chat
+
+	Players   []peer.ID     `json:",omitempty"` // Start
+	Teams     []int         `json:",omitempty"` // Start, the team of each of Players
+	Countdown time.Duration `json:",omitempty"` // Start
+	Seed      uint32        `json:",omitempty"` // Seed
+	Delay     int           `json:",omitempty"` // Start, Tune
+	TickRate  time.Duration `json:",omitempty"` // Start, Tune
+	RTT       []peerRTT     `json:",omitempty"` // Latency
+
+	// History holds the completed ticks starting from Tick. It is a list
+	// and not core.PlayerMoves, because encoding/json mangles the raw
+	// peer.ID map keys.
+	History [][]playerMove `json:",omitempty"` // Replay
+	Moves   []playerMove   `json:",omitempty"` // Vote, Equivocation
+	Answer  bool           `json:",omitempty"` // Vote, do not answer back
+	Digests []moveDigest   `json:",omitempty"` // Echo
+}
+
+func (msg GameMessage) timing() core.Timing {
+	return core.Timing{
+		Delay:    msg.Delay,
+		TickRate: msg.TickRate,
+	}
+}
+
+func moveMessage(tick int, m playerMove) GameMessage {
+	return GameMessage{
+		Type:   Move,
+		Dir:    m.Dir,
+		Tick:   tick,
+		Evict:  m.Evict,
+		Causes: m.Causes,
+		Prev:   m.Prev,
+		Sig:    m.Sig,
+	}
+}
+
+func (msg GameMessage) move(from peer.ID) playerMove {
+	return playerMove{
+		ID:     from,
+		Dir:    msg.Dir,
+		Evict:  msg.Evict,
+		Causes: msg.Causes,
+		Prev:   msg.Prev,
+		Sig:    msg.Sig,
+	}
+}
+
+// write stamps the message with our round and sends it. Must be called
+// with the lock held.
+func (gi *GameInstance) write(w io.Writer, msg GameMessage) error {
+	msg.Round = gi.round
+	return writeMessage(w, msg)
+}
+
+// current tells whether the message belongs to the round we are in. The
+// ready confirmations of the next round can come before we get to it.
+func (gi *GameInstance) current(msg GameMessage) bool {
+	switch msg.Type {
+	case Chat, Latency:
+		return true
+	}
+
+	gi.mu.Lock()
+	round := gi.round
+	gi.mu.Unlock()
+
+	if msg.Type == Ready {
+		return msg.Round >= round
+	}
+	return msg.Round == round
+}
+
+// maxMessageSize bounds a game message. The largest is Replay, which is
+// sent in chunks of replayChunk ticks to stay below it.
+const maxMessageSize = 1 << 20
+
+// newScanner splits the game stream into messages.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4<<10), maxMessageSize)
+	return scanner
+}
+
+func writeMessage(w io.Writer, msg GameMessage) error {
+	raw, err := json.Marshal(&msg)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	raw = append(raw, '\n')
+
+	_, err = w.Write(raw)
+	if err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+
+	return nil
+}
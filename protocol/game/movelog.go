@@ -0,0 +1,272 @@
+package game
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// moveDomain separates the move signatures from anything else signed
+// with the same key.
+const moveDomain = "snake_p2p/move"
+
+var (
+	ErrNoKey        = errors.New("no public key of the player")
+	ErrBadSignature = errors.New("bad move signature")
+	ErrBrokenChain  = errors.New("move is chained to an unknown history")
+)
+
+// content is what the signature of the move covers.
+func (m playerMove) content(tick int) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(moveDomain)
+	writeInt(&buf, int64(tick))
+	writeInt(&buf, int64(m.Dir))
+	writeBytes(&buf, m.Prev)
+
+	writeInt(&buf, int64(len(m.Evict)))
+	for _, id := range m.Evict {
+		writeBytes(&buf, []byte(id))
+	}
+
+	writeInt(&buf, int64(len(m.Causes)))
+	for _, cause := range m.Causes {
+		writeInt(&buf, int64(cause))
+	}
+
+	return buf.Bytes()
+}
+
+func writeInt(buf *bytes.Buffer, n int64) {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], uint64(n))
+	buf.Write(raw[:])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeInt(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+// recordHash chains the complete move set of the tick to the hash of the
+// previous tick.
+func recordHash(prev []byte, tick int, r tickRecord) []byte {
+	ids := make([]peer.ID, 0, len(r.By))
+	for id := range r.By {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var buf bytes.Buffer
+	writeBytes(&buf, prev)
+	for _, id := range ids {
+		m := r.By[id]
+
+		writeBytes(&buf, []byte(id))
+		writeBytes(&buf, m.content(tick))
+		if m.Missed {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:]
+}
+
+// sign signs our move for the tick, chaining it to the last completed
+// tick. In the rollback mode, that tick can be a few ticks behind. Must be
+// called with the lock held.
+func (gi *GameInstance) sign(tick int, m playerMove) (playerMove, error) {
+	m.ID = gi.selfID
+	m.Prev = gi.head
+
+	sig, err := gi.key.Sign(m.content(tick))
+	if err != nil {
+		return m, fmt.Errorf("sign move: %v", err)
+	}
+	m.Sig = sig
+
+	return m, nil
+}
+
+// verifyMove checks the signature of the move. Must be called with the
+// lock held.
+func (gi *GameInstance) verifyMove(tick int, m playerMove) error {
+	return verifyMove(gi.keys, tick, m)
+}
+
+func verifyMove(keys map[peer.ID]crypto.PubKey, tick int, m playerMove) error {
+	key, exists := keys[m.ID]
+	if !exists {
+		return ErrNoKey
+	}
+
+	ok, err := key.Verify(m.content(tick), m.Sig)
+	if err != nil {
+		return fmt.Errorf("verify move: %v", err)
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// witness compares the move someone else has received from the player
+// with the one we have. Two different moves signed by the player for the
+// same tick prove the player equivocates. Must be called with the lock
+// held.
+func (gi *GameInstance) witness(tick int, m playerMove) {
+	if m.Missed {
+		return
+	}
+
+	var ours playerMove
+	var known bool
+	if tick < gi.next {
+		ours, known = gi.history[tick].By[m.ID]
+	} else {
+		ours, known = gi.pending[tick][m.ID]
+	}
+
+	if !known || ours.Missed || bytes.Equal(ours.content(tick), m.content(tick)) {
+		return
+	}
+
+	if gi.verifyMove(tick, m) != nil {
+		return
+	}
+
+	gi.send(GameMessage{
+		Type:  Equivocation,
+		Tick:  tick,
+		Moves: []playerMove{ours, m},
+	})
+
+	gi.flag(m.ID, tick)
+}
+
+// receiveEquivocation checks the proof of equivocation another player has
+// sent us.
+func (gi *GameInstance) receiveEquivocation(from peer.ID, tick int, proof []playerMove) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if len(proof) != 2 || proof[0].ID != proof[1].ID ||
+		bytes.Equal(proof[0].content(tick), proof[1].content(tick)) {
+		log.Warn().Str("player", from.Pretty()).Msg("Malformed equivocation proof")
+		return
+	}
+
+	for _, m := range proof {
+		if err := gi.verifyMove(tick, m); err != nil {
+			log.Warn().Err(err).
+				Str("player", from.Pretty()).
+				Msg("Equivocation proof does not verify")
+			return
+		}
+	}
+
+	gi.flag(proof[0].ID, tick)
+}
+
+// flag tells the UI about the equivocating player and proposes to evict
+// them. Must be called with the lock held.
+func (gi *GameInstance) flag(p peer.ID, tick int) {
+	if gi.flagged[p] {
+		return
+	}
+	gi.flagged[p] = true
+
+	log.Error().
+		Str("player", p.Pretty()).
+		Int("tick", tick).
+		Msg("Player sent different moves to different players")
+
+	gi.events = append(gi.events, core.Equivocation{SnakeID: p, Tick: tick})
+	gi.propose(p, core.Evicted)
+	gi.wake()
+}
+
+// MoveLog is the history of the game in which every move is signed by
+// its player and chained to an earlier tick. The substituted moves are
+// not signed, they are agreed on by the vote.
+type MoveLog struct {
+	keys  map[peer.ID]crypto.PubKey
+	ticks []tickRecord
+}
+
+// Log returns the move log of the completed ticks.
+func (gi *GameInstance) Log() MoveLog {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	keys := make(map[peer.ID]crypto.PubKey, len(gi.keys))
+	for id, key := range gi.keys {
+		keys[id] = key
+	}
+
+	return MoveLog{
+		keys:  keys,
+		ticks: append([]tickRecord(nil), gi.history...),
+	}
+}
+
+// Len returns the number of ticks in the log.
+func (l MoveLog) Len() int {
+	return len(l.ticks)
+}
+
+// Head returns the hash of the last tick, the peers with equal heads have
+// equal logs.
+func (l MoveLog) Head() []byte {
+	if len(l.ticks) == 0 {
+		return nil
+	}
+
+	return l.ticks[len(l.ticks)-1].Hash
+}
+
+// Verify checks the signatures and the hash chain of the whole log. Every
+// move must be chained to one of the earlier ticks.
+func (l MoveLog) Verify() error {
+	var prev []byte
+	known := map[string]bool{"": true}
+
+	for tick, r := range l.ticks {
+		for id, m := range r.By {
+			if m.Missed {
+				continue
+			}
+
+			if err := verifyMove(l.keys, tick, m); err != nil {
+				return fmt.Errorf("tick %d, player %s: %w", tick, id.Pretty(), err)
+			}
+
+			if !known[string(m.Prev)] {
+				return fmt.Errorf("tick %d, player %s: %w", tick, id.Pretty(), ErrBrokenChain)
+			}
+		}
+
+		prev = recordHash(prev, tick, r)
+		if !bytes.Equal(prev, r.Hash) {
+			return fmt.Errorf("tick %d: hash mismatch", tick)
+		}
+		known[string(prev)] = true
+	}
+
+	return nil
+}
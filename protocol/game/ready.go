@@ -0,0 +1,208 @@
+package game
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ReadyTimeout is how long the players have to confirm they are ready.
+	ReadyTimeout = 15 * time.Second
+
+	// Countdown is how long the players wait after the ready check,
+	// before the first tick.
+	Countdown = 3 * time.Second
+
+	// startSlack accounts for the facilitator's start message delivery.
+	startSlack = 5 * time.Second
+)
+
+var (
+	ErrNotReady          = errors.New("did not confirm readiness in time")
+	ErrNotEnoughPlayers  = errors.New("not enough players are ready")
+	ErrNoStartFromLeader = errors.New("facilitator did not start the game")
+)
+
+// Ready confirms that the local player is ready to play.
+func (gi *GameInstance) Ready() {
+	if gi.facilitator == gi.selfID {
+		gi.readyCh <- gi.selfID
+		return
+	}
+
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	s, exists := gi.streams[gi.facilitator]
+	if !exists {
+		log.Error().Msg("No game connection with the facilitator to confirm readiness")
+		return
+	}
+
+	err := gi.write(s, GameMessage{Type: Ready})
+	if err != nil {
+		log.Err(err).Msg("Confirm readiness")
+	}
+}
+
+// ReadyCheck waits until the players confirm their readiness. The
+// facilitator collects the confirmations and tells everyone who plays.
+// The players that did not confirm in time are disconnected. On success,
+// the time left before the first tick is returned.
+func (gi *GameInstance) ReadyCheck() (time.Duration, error) {
+	var start GameMessage
+
+	if gi.facilitator == gi.selfID {
+		start = gi.collectReady()
+		gi.broadcast(start)
+	} else {
+		timer := time.NewTimer(ReadyTimeout + startSlack)
+		defer timer.Stop()
+
+		select {
+		case start = <-gi.startCh:
+		case <-timer.C:
+			return 0, ErrNoStartFromLeader
+		}
+	}
+
+	chosen := make(map[peer.ID]struct{}, len(start.Players))
+	for _, id := range start.Players {
+		chosen[id] = struct{}{}
+	}
+
+	for _, id := range gi.PlayersIDs() {
+		if _, ok := chosen[id]; !ok && id != gi.selfID {
+			log.Info().Str("player", id.Pretty()).Msg("Player dropped, not ready")
+			gi.RemovePeer(id)
+		}
+	}
+
+	if _, ok := chosen[gi.selfID]; !ok {
+		return 0, ErrNotReady
+	}
+
+	if len(start.Players) < 2 {
+		return 0, ErrNotEnoughPlayers
+	}
+
+	gi.mu.Lock()
+	if start.Delay >= 1 && start.TickRate > 0 {
+		gi.timing = start.timing()
+	}
+	gi.teams = gi.assignedTeams(start)
+	gi.mu.Unlock()
+
+	return start.Countdown, nil
+}
+
+func (gi *GameInstance) collectReady() GameMessage {
+	ready := make(map[peer.ID]struct{})
+	timer := time.NewTimer(ReadyTimeout)
+	defer timer.Stop()
+
+loop:
+	for len(ready) < gi.PeerCount()+1 {
+		select {
+		case id := <-gi.readyCh:
+			ready[id] = struct{}{}
+			log.Info().Str("player", id.Pretty()).Msg("Player is ready")
+		case <-timer.C:
+			log.Warn().
+				Int("ready", len(ready)).
+				Int("total", gi.PeerCount()+1).
+				Msg("Ready check timed out")
+			break loop
+		}
+	}
+
+	players := make([]peer.ID, 0, len(ready))
+	for id := range ready {
+		players = append(players, id)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i] < players[j]
+	})
+
+	// The latency has been measured since Start, while the players were
+	// getting ready.
+	gi.mu.Lock()
+	timing := pace(gi.worstRTT(), gi.settings.TickRate)
+	gi.mu.Unlock()
+
+	// The players are dealt into the teams one by one.
+	var teams []int
+	if gi.settings.Teams > 0 {
+		teams = make([]int, len(players))
+		for i := range players {
+			teams[i] = i%gi.settings.Teams + 1
+		}
+	}
+
+	log.Info().
+		Int("delay", timing.Delay).
+		Dur("tick_rate", timing.TickRate).
+		Msg("Picked timing")
+
+	return GameMessage{
+		Type:      Start,
+		Players:   players,
+		Teams:     teams,
+		Countdown: Countdown,
+		Delay:     timing.Delay,
+		TickRate:  timing.TickRate,
+	}
+}
+
+// assignedTeams checks the teams the facilitator has dealt the players
+// into. It is nil outside of the team mode. Must be called with the lock
+// held.
+func (gi *GameInstance) assignedTeams(start GameMessage) map[peer.ID]int {
+	if gi.settings.Teams == 0 {
+		return nil
+	}
+
+	if len(start.Teams) != len(start.Players) {
+		log.Error().
+			Int("teams", len(start.Teams)).
+			Int("players", len(start.Players)).
+			Msg("Start does not assign every player to a team")
+		return nil
+	}
+
+	teams := make(map[peer.ID]int, len(start.Players))
+	for i, id := range start.Players {
+		team := start.Teams[i]
+		if team < 1 || team > gi.settings.Teams {
+			log.Error().
+				Str("player", id.Pretty()).
+				Int("team", team).
+				Msg("Start assigns the player to an unknown team")
+			return nil
+		}
+		teams[id] = team
+	}
+
+	return teams
+}
+
+// Teams returns the team of every player in the team mode, nil otherwise.
+func (gi *GameInstance) Teams() map[peer.ID]int {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if gi.teams == nil {
+		return nil
+	}
+
+	teams := make(map[peer.ID]int, len(gi.teams))
+	for id, team := range gi.teams {
+		teams[id] = team
+	}
+
+	return teams
+}
@@ -0,0 +1,319 @@
+package game
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ReconnectGrace is how long a player that lost the connection can
+	// take to come back, before they are dropped from the game.
+	ReconnectGrace = 10 * time.Second
+
+	// RedialEvery is the pause between the reconnection attempts.
+	RedialEvery = 500 * time.Millisecond
+)
+
+// replayChunk is how many ticks a Replay message carries at most.
+const replayChunk = 16
+
+// Away returns the players that are currently reconnecting.
+func (gi *GameInstance) Away() []peer.ID {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	var ids []peer.ID
+	for id := range gi.away {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// lose handles the broken game stream of the player. During the game the
+// player is given ReconnectGrace to come back. The player with the lower
+// ID redials, so that the two do not race each other.
+func (gi *GameInstance) lose(p peer.ID, s network.Stream) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	// The stream was replaced or the player was removed on purpose.
+	if gi.streams[p] != s {
+		return
+	}
+	delete(gi.streams, p)
+
+	if !gi.running {
+		log.Warn().Str("player", p.Pretty()).Msg("Lost game connection before the game")
+		delete(gi.players, p)
+		return
+	}
+
+	log.Warn().
+		Str("player", p.Pretty()).
+		Int("tick", gi.next).
+		Msg("Lost game connection, waiting for the player to reconnect")
+
+	var t *time.Timer
+	t = time.AfterFunc(ReconnectGrace, func() { gi.expire(p, t) })
+	gi.away[p] = t
+
+	if gi.selfID < p && gi.dial != nil {
+		gi.spawn(func() { gi.redial(p) })
+	}
+
+	gi.wake()
+}
+
+// expire proposes to evict the player that did not come back in time. If
+// we are the ones cut off from the majority, the game cannot go on.
+func (gi *GameInstance) expire(p peer.ID, t *time.Timer) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if !gi.running || gi.away[p] != t {
+		return
+	}
+
+	if !gi.majority() {
+		log.Error().
+			Str("player", p.Pretty()).
+			Msg("Cut off from the majority of the players")
+
+		delete(gi.away, p)
+		gi.events = append(gi.events, core.GameOver{Successful: false, Stats: gi.stats()})
+		gi.wake()
+		return
+	}
+
+	log.Warn().Str("player", p.Pretty()).Msg("Player did not reconnect in time")
+
+	gi.propose(p, core.Disconnect)
+}
+
+func (gi *GameInstance) redial(p peer.ID) {
+	ticker := time.NewTicker(RedialEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gi.done:
+			return
+		case <-ticker.C:
+		}
+
+		gi.mu.Lock()
+		_, away := gi.away[p]
+		gi.mu.Unlock()
+
+		if !away {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ReconnectGrace)
+		s, err := gi.dial(ctx, p)
+		cancel()
+		if err != nil {
+			log.Debug().Err(err).
+				Str("player", p.Pretty()).
+				Msg("Redial player")
+			continue
+		}
+
+		gi.AddPeer(s)
+		return
+	}
+}
+
+// resume attaches the new stream of a player from the roster and tells
+// them which tick we are at. The secure channel of libp2p already proves
+// that the stream comes from the player. Must be called with the lock
+// held.
+func (gi *GameInstance) resume(s network.Stream) {
+	p := s.Conn().RemotePeer()
+
+	if _, playing := gi.players[p]; !playing {
+		log.Warn().Str("peer", p.Pretty()).Msg("Reject game stream of a non-player")
+
+		err := s.Reset()
+		if err != nil {
+			log.Err(err).Msg("Reset game stream")
+		}
+		return
+	}
+
+	if old, exists := gi.streams[p]; exists && old != s {
+		err := old.Close()
+		if err != nil {
+			log.Err(err).Msg("Close replaced game stream")
+		}
+	}
+	gi.streams[p] = s
+
+	if t, away := gi.away[p]; away {
+		t.Stop()
+		delete(gi.away, p)
+	}
+
+	log.Info().
+		Str("player", p.Pretty()).
+		Int("tick", gi.next).
+		Msg("Player reconnected")
+
+	gi.spawn(func() { gi.readLoop(s) })
+
+	err := gi.write(s, GameMessage{Type: Resume, Tick: gi.next})
+	if err != nil {
+		log.Err(err).Str("player", p.Pretty()).Msg("Send resume")
+	}
+
+	gi.wake()
+}
+
+// sendReplay sends the player the ticks they have missed since the tick,
+// in chunks of replayChunk, and our moves they might not have received.
+func (gi *GameInstance) sendReplay(p peer.ID, tick int) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	s, exists := gi.streams[p]
+	if !exists || tick < 0 {
+		return
+	}
+
+	for start := tick; start < len(gi.history); start += replayChunk {
+		end := start + replayChunk
+		if end > len(gi.history) {
+			end = len(gi.history)
+		}
+
+		msg := GameMessage{
+			Type: Replay,
+			Tick: start,
+		}
+
+		for _, record := range gi.history[start:end] {
+			msg.History = append(msg.History, record.wire())
+		}
+
+		err := gi.write(s, msg)
+		if err != nil {
+			log.Err(err).Str("player", p.Pretty()).Msg("Send replay")
+			return
+		}
+	}
+
+	for t, moves := range gi.pending {
+		m, moved := moves[gi.selfID]
+		if !moved {
+			continue
+		}
+
+		err := gi.write(s, moveMessage(t, m))
+		if err != nil {
+			log.Err(err).Str("player", p.Pretty()).Msg("Resend move")
+			return
+		}
+	}
+}
+
+// applyReplay completes our missing ticks with the ones the player has
+// sent. The replay is dropped from the first tick that does not check out.
+func (gi *GameInstance) applyReplay(p peer.ID, tick int, history [][]playerMove) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if _, playing := gi.players[p]; !playing {
+		return
+	}
+
+	for i, wire := range history {
+		if tick+i > gi.next {
+			break
+		}
+
+		if tick+i == gi.next {
+			if err := gi.checkReplayed(tick+i, wire); err != nil {
+				log.Warn().Err(err).
+					Str("player", p.Pretty()).
+					Int("tick", tick+i).
+					Msg("Replay carries a bad tick")
+				return
+			}
+		}
+
+		for _, m := range wire {
+			if m.Missed {
+				continue
+			}
+
+			if err := gi.verifyMove(tick+i, m); err != nil {
+				log.Warn().Err(err).
+					Str("player", p.Pretty()).
+					Str("mover", m.ID.Pretty()).
+					Msg("Replay carries a move with a bad signature")
+				return
+			}
+
+			gi.witness(tick+i, m)
+		}
+
+		if tick+i == gi.next {
+			gi.complete(recordFromWire(wire))
+		}
+	}
+
+	log.Info().
+		Str("player", p.Pretty()).
+		Int("tick", gi.next).
+		Msg("Caught up with the replay")
+
+	gi.wake()
+}
+
+// checkReplayed checks the replayed moves of the next tick against the
+// roster: every player has exactly one move, and the substituted ones are
+// made as fill makes them, with the last direction and unsigned. The
+// signatures of the others are checked by the caller. Must be called with
+// the lock held.
+func (gi *GameInstance) checkReplayed(tick int, wire []playerMove) error {
+	roster := gi.roster()
+	if len(wire) != len(roster) {
+		return fmt.Errorf("%d moves for %d players", len(wire), len(roster))
+	}
+
+	playing := make(map[peer.ID]bool, len(roster))
+	for _, id := range roster {
+		playing[id] = true
+	}
+
+	for _, m := range wire {
+		if !playing[m.ID] {
+			return fmt.Errorf("unexpected move of %s", m.ID.Pretty())
+		}
+		playing[m.ID] = false
+
+		if !m.Missed {
+			if gi.isEliminated(m.ID, tick) {
+				return fmt.Errorf("move of the eliminated %s", m.ID.Pretty())
+			}
+			continue
+		}
+
+		if m.Dir != gi.lastDir[m.ID] || len(m.Evict) > 0 || len(m.Causes) > 0 || m.Prev != nil || m.Sig != nil {
+			return fmt.Errorf("forged substitute for %s", m.ID.Pretty())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,527 @@
+package game
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/engine/rules"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// MoveDeadline is how long we wait for the other players' moves after
+	// we have made ours. When it passes, the players vote on the moves
+	// they have, and the missing ones are substituted.
+	MoveDeadline = 1 * time.Second
+
+	// MaxMissedMoves is how many substituted moves in a row get a player
+	// evicted.
+	MaxMissedMoves = 10
+)
+
+// maxAhead is how many ticks in advance a move is accepted.
+const maxAhead = 32
+
+// tickRecord is a completed tick.
+type tickRecord struct {
+	Moves core.PlayerMoves
+	By    map[peer.ID]playerMove // the moves as they were sent
+	Hash  []byte                 // refer to MoveLog
+}
+
+// TickPlayed is a completed tick as the rules have played it. World is
+// the world after the tick, and Events are what happened in it, refer to
+// rules.World. The UI draws the world the game instance plays, and does
+// not need one of its own.
+type TickPlayed struct {
+	core.PlayerMoves
+	World  rules.Snapshot
+	Events []interface{}
+}
+
+func newTickRecord() tickRecord {
+	return tickRecord{
+		Moves: core.PlayerMoves{
+			Moves: make(map[peer.ID]core.Direction),
+		},
+		By: make(map[peer.ID]playerMove),
+	}
+}
+
+func (r tickRecord) add(m playerMove) {
+	r.Moves.Moves[m.ID] = m.Dir
+	r.By[m.ID] = m
+}
+
+func (r tickRecord) wire() []playerMove {
+	var wire []playerMove
+	for _, m := range r.By {
+		wire = append(wire, m)
+	}
+
+	return wire
+}
+
+func recordFromWire(wire []playerMove) tickRecord {
+	r := newTickRecord()
+	for _, m := range wire {
+		r.add(m)
+	}
+
+	return r
+}
+
+// receiveMove stores the move the player has sent us, if it is signed by
+// them.
+func (gi *GameInstance) receiveMove(tick int, m playerMove) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if _, playing := gi.players[m.ID]; !playing {
+		return
+	}
+
+	if err := gi.verifyMove(tick, m); err != nil {
+		log.Warn().Err(err).
+			Str("player", m.ID.Pretty()).
+			Int("tick", tick).
+			Msg("Drop move")
+		return
+	}
+
+	gi.addMove(tick, m)
+}
+
+// addMove stores the player's move for the tick. Must be called with the
+// lock held.
+func (gi *GameInstance) addMove(tick int, m playerMove) {
+	p := m.ID
+	if tick < gi.next || tick >= gi.next+maxAhead {
+		log.Debug().
+			Str("peer", p.Pretty()).
+			Int("tick", tick).
+			Int("next", gi.next).
+			Msg("Drop move out of the tick window")
+		return
+	}
+
+	moves, ok := gi.pending[tick]
+	if !ok {
+		moves = make(map[peer.ID]playerMove)
+		gi.pending[tick] = moves
+	}
+
+	if first, moved := moves[p]; moved {
+		if !bytes.Equal(first.content(tick), m.content(tick)) {
+			gi.report(p, tick, "more than one move in a tick")
+		}
+		return
+	}
+
+	log.Debug().
+		Str("peer", p.Pretty()).
+		Int("tick", tick).
+		Int("dir", int(m.Dir)).
+		Msg("Received move")
+
+	m.Missed = false
+	moves[p] = m
+	gi.wake()
+}
+
+// receiveVote stores the moves the player has for the tick. A vote for a
+// tick we have completed is answered with our record of it, so that the
+// late voter does not wait for us.
+func (gi *GameInstance) receiveVote(p peer.ID, tick int, moves []playerMove, answer bool) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if _, playing := gi.players[p]; !playing {
+		return
+	}
+
+	if tick < 0 || tick >= gi.next+maxAhead {
+		return
+	}
+
+	// Only keep the moves signed by their players, and check them against
+	// ours.
+	var valid []playerMove
+	for _, m := range moves {
+		if !m.Missed && gi.verifyMove(tick, m) != nil {
+			log.Warn().
+				Str("player", p.Pretty()).
+				Str("mover", m.ID.Pretty()).
+				Msg("Vote carries a move with a bad signature")
+			continue
+		}
+
+		gi.witness(tick, m)
+		valid = append(valid, m)
+	}
+
+	if tick < gi.next {
+		s, exists := gi.streams[p]
+		if !exists || answer {
+			return
+		}
+
+		err := gi.write(s, GameMessage{
+			Type:   Vote,
+			Tick:   tick,
+			Moves:  gi.history[tick].wire(),
+			Answer: true,
+		})
+		if err != nil {
+			log.Err(err).Str("player", p.Pretty()).Msg("Answer late vote")
+		}
+		return
+	}
+
+	votes, ok := gi.votes[tick]
+	if !ok {
+		votes = make(map[peer.ID][]playerMove)
+		gi.votes[tick] = votes
+	}
+
+	// A player who has voted can answer our vote later with the record,
+	// the vote they have sent the others counts.
+	if _, dup := votes[p]; dup {
+		return
+	}
+	votes[p] = valid
+
+	gi.wake()
+}
+
+// vote sends everyone the moves we have for the next tick, and counts
+// them as our own vote. Must be called with the lock held.
+func (gi *GameInstance) vote() {
+	tick := gi.next
+	if gi.voted[tick] {
+		return
+	}
+	gi.voted[tick] = true
+
+	var moves []playerMove
+	for _, m := range gi.pending[tick] {
+		moves = append(moves, m)
+	}
+
+	votes, ok := gi.votes[tick]
+	if !ok {
+		votes = make(map[peer.ID][]playerMove)
+		gi.votes[tick] = votes
+	}
+	votes[gi.selfID] = moves
+
+	log.Debug().
+		Int("tick", tick).
+		Int("moves", len(moves)).
+		Msg("Vote on the moves")
+
+	gi.send(GameMessage{
+		Type:  Vote,
+		Tick:  tick,
+		Moves: moves,
+	})
+}
+
+// wake makes syncLoop recheck whether the next tick can be completed.
+func (gi *GameInstance) wake() {
+	select {
+	case gi.poke <- struct{}{}:
+	default:
+	}
+}
+
+// majority tells whether we are connected to the strict majority of the
+// roster, ourselves included. Must be called with the lock held.
+func (gi *GameInstance) majority() bool {
+	connected := len(gi.players) - len(gi.away) + 1
+	return connected*2 > len(gi.players)+1
+}
+
+// advance completes the ticks for which every active player has moved.
+// Otherwise the tick waits for the vote: once anyone votes, everyone does,
+// and the tick is completed with the moves in the votes of the voters,
+// refer to voters. The moves that arrive after we have voted do not
+// count, the others may not have them. A peer that has completed the tick
+// before hearing of the vote answers with its record, which has every
+// move. The players without a move in any vote keep their last direction.
+// If players are away, only the majority side can go on. Must be called
+// with the lock held.
+func (gi *GameInstance) advance() {
+	for {
+		tick := gi.next
+		moves := gi.pending[tick]
+
+		if gi.halted {
+			return
+		}
+
+		if len(gi.votes[tick]) > 0 {
+			gi.vote()
+		}
+
+		if !gi.voted[tick] && gi.allMoved(tick, moves) {
+			gi.complete(gi.fill(tick, moves))
+			continue
+		}
+
+		if !gi.voted[tick] || !gi.votesIn(tick) {
+			return
+		}
+
+		if len(gi.away) > 0 && !gi.majority() {
+			return
+		}
+
+		seen := make(map[peer.ID]playerMove)
+		for _, id := range gi.voters(tick) {
+			for _, m := range gi.votes[tick][id] {
+				if _, ok := seen[m.ID]; !ok && !m.Missed {
+					seen[m.ID] = m
+				}
+			}
+		}
+
+		gi.complete(gi.fill(tick, seen))
+	}
+}
+
+// fill makes the record of the tick out of the moves. The players without
+// a move and the eliminated ones keep their last direction. The latter
+// are substituted even if they have moved: every peer eliminates them
+// when completing the tick they die in, refer to judge, so the record
+// does not depend on which of their moves are still in flight. Must be
+// called with the lock held.
+func (gi *GameInstance) fill(tick int, moves map[peer.ID]playerMove) tickRecord {
+	record := newTickRecord()
+	for _, id := range gi.roster() {
+		m, ok := moves[id]
+		if !ok || gi.isEliminated(id, tick) {
+			m = playerMove{ID: id, Dir: gi.lastDir[id], Missed: true}
+		}
+
+		record.add(m)
+	}
+
+	return record
+}
+
+// roster lists the players including ourselves. Must be called with the
+// lock held.
+func (gi *GameInstance) roster() []peer.ID {
+	ids := make([]peer.ID, 0, len(gi.players)+1)
+	for id := range gi.players {
+		ids = append(ids, id)
+	}
+	ids = append(ids, gi.selfID)
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// active lists the players whose moves the tick needs. Must be called
+// with the lock held.
+func (gi *GameInstance) active(tick int) []peer.ID {
+	var ids []peer.ID
+	for _, id := range gi.roster() {
+		if !gi.isEliminated(id, tick) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (gi *GameInstance) isEliminated(p peer.ID, tick int) bool {
+	from, out := gi.eliminated[p]
+	return out && tick >= from
+}
+
+func (gi *GameInstance) allMoved(tick int, moves map[peer.ID]playerMove) bool {
+	for _, id := range gi.active(tick) {
+		if _, moved := moves[id]; !moved {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Waiting lists the players whose moves the next tick still waits for,
+// not counting ourselves.
+func (gi *GameInstance) Waiting() []peer.ID {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	if !gi.running || gi.halted {
+		return nil
+	}
+
+	var ids []peer.ID
+	for _, id := range gi.active(gi.next) {
+		if _, moved := gi.pending[gi.next][id]; !moved && id != gi.selfID {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// voters lists the players whose votes make the record of the tick: the
+// active ones that are not away, ourselves included. Every voter that is
+// still playing has its own move in its vote, if it has made one. Must be
+// called with the lock held.
+func (gi *GameInstance) voters(tick int) []peer.ID {
+	var ids []peer.ID
+	for _, id := range gi.active(tick) {
+		if _, away := gi.away[id]; !away {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// votesIn tells whether every voter has voted in the tick. Must be called
+// with the lock held.
+func (gi *GameInstance) votesIn(tick int) bool {
+	for _, id := range gi.voters(tick) {
+		if _, voted := gi.votes[tick][id]; !voted {
+			return false
+		}
+	}
+
+	return true
+}
+
+// complete records the moves of the next tick, chains it to the previous
+// one, plays it by the rules and queues the outcome for the UI.
+// Every peer has the same record, so they all count the missed moves and
+// the eviction proposals the same way, and evict at the same tick. Must be
+// called with the lock held.
+func (gi *GameInstance) complete(record tickRecord) {
+	record.Moves.Tick = gi.next
+
+	for p, m := range record.By {
+		if !m.Missed {
+			gi.validate(record, m)
+		}
+		gi.lastDir[p] = record.Moves.Moves[p]
+
+		if !m.Missed && !gi.hashes[string(m.Prev)] {
+			log.Warn().
+				Str("player", p.Pretty()).
+				Int("tick", gi.next).
+				Msg("Player's move is chained to a different history")
+		}
+	}
+
+	record.Hash = recordHash(gi.head, gi.next, record)
+	gi.head = record.Hash
+	gi.hashes[string(gi.head)] = true
+
+	gi.history = append(gi.history, record)
+	gi.judge(record)
+	delete(gi.pending, gi.next)
+	delete(gi.votes, gi.next)
+	delete(gi.voted, gi.next)
+
+	gi.next++
+	if gi.sent < gi.next {
+		gi.sent = gi.next
+	}
+
+	for p, m := range record.By {
+		if !m.Missed || gi.isEliminated(p, record.Moves.Tick) {
+			gi.missed[p] = 0
+			continue
+		}
+
+		gi.missed[p]++
+		if gi.missed[p] == MaxMissedMoves && p != gi.selfID {
+			gi.propose(p, core.Timeout)
+		}
+	}
+
+	gi.tally(record)
+	gi.events = append(gi.events, gi.played(record))
+	gi.echo(record)
+}
+
+// syncLoop is the only one to deliver the completed ticks, so that the UI
+// receives them in order. Other game events that affect the roster go
+// through it too. It also keeps the deadline of the tick we have moved
+// in.
+func (gi *GameInstance) syncLoop(over <-chan struct{}) {
+	deadline := time.NewTimer(MoveDeadline)
+	deadline.Stop()
+	defer deadline.Stop()
+
+	armed := -1
+	expired := false
+
+	for {
+		select {
+		case <-gi.done:
+			return
+		case <-over:
+			return
+		case <-gi.poke:
+		case <-deadline.C:
+			expired = true
+		}
+
+		gi.mu.Lock()
+		select {
+		case <-over:
+			// The rematch has already reset the state.
+			gi.mu.Unlock()
+			return
+		default:
+		}
+
+		if expired && armed == gi.next {
+			log.Warn().Int("tick", armed).Msg("Move deadline passed")
+			gi.vote()
+		}
+		expired = false
+
+		gi.advance()
+
+		_, moved := gi.pending[gi.next][gi.selfID]
+		if moved && armed != gi.next {
+			armed = gi.next
+			if !deadline.Stop() {
+				select {
+				case <-deadline.C:
+				default:
+				}
+			}
+			deadline.Reset(MoveDeadline)
+		}
+
+		events := gi.events
+		gi.events = nil
+		gi.mu.Unlock()
+
+		for _, e := range events {
+			select {
+			case gi.recv <- e:
+			case <-over:
+				return
+			case <-gi.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package game
+
+import (
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rs/zerolog/log"
+)
+
+// validate checks the move against the rules. Every peer checks the same
+// record, so an invalid move turns into the previous direction
+// everywhere. The signed move stays in the record as it was sent. Must be
+// called with the lock held.
+func (gi *GameInstance) validate(record tickRecord, m playerMove) {
+	last, moved := gi.lastDir[m.ID]
+
+	reason := ""
+	switch {
+	case !m.Dir.Valid():
+		reason = "unknown direction"
+	case moved && m.Dir == last.Opposite():
+		reason = "reversal"
+	default:
+		return
+	}
+
+	record.Moves.Moves[m.ID] = last
+	gi.report(m.ID, record.Moves.Tick, reason)
+}
+
+// report tells the UI about the invalid move. Must be called with the
+// lock held.
+func (gi *GameInstance) report(p peer.ID, tick int, reason string) {
+	log.Warn().
+		Str("player", p.Pretty()).
+		Int("tick", tick).
+		Str("reason", reason).
+		Msg("Invalid move")
+
+	gi.events = append(gi.events, core.InvalidMove{
+		SnakeID: p,
+		Tick:    tick,
+		Reason:  reason,
+	})
+	gi.wake()
+}
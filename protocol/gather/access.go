@@ -0,0 +1,146 @@
+package gather
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// HandshakeTimeout bounds how long both sides wait for each other while
+// a seeker is being admitted to a gather point.
+var HandshakeTimeout = 10 * time.Second
+
+var (
+	ErrAccessDenied = errors.New("access denied")
+	ErrRoomBusy     = errors.New("room is playing or full")
+)
+
+const nonceSize = 32
+
+// Access describes who may join a gather point.
+type Access struct {
+	// Hidden gather points are never announced on the pub/sub topic.
+	// Seekers can only join them through an invite.
+	Hidden bool
+
+	// Passphrase, if not empty, has to be proven by every seeker with
+	// a challenge-response before it is admitted.
+	Passphrase string
+}
+
+// prove computes a response to the facilitator's challenge. The seeker's
+// ID is mixed in, so that a response overheard by someone else cannot
+// be replayed under their own identity.
+func prove(passphrase string, nonce []byte, seeker peer.ID) []byte {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+	mac.Write(nonce)
+	mac.Write([]byte(seeker))
+	return mac.Sum(nil)
+}
+
+// admit runs the facilitator's side of the handshake. Seekers of a
+// protected gather point are challenged first. Those who are let in
+// receive the welcome message, it carries the ID of the game being
+// gathered.
+func admit(stream network.Stream, scanner *bufio.Scanner, access Access, welcome GatherMessage) error {
+	err := stream.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		return fmt.Errorf("set deadline: %v", err)
+	}
+	defer stream.SetReadDeadline(time.Time{})
+
+	if access.Passphrase != "" {
+		nonce := make([]byte, nonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("generate nonce: %v", err)
+		}
+
+		err := writeMessage(stream, GatherMessage{Type: Challenge, Nonce: nonce})
+		if err != nil {
+			return fmt.Errorf("send challenge: %v", err)
+		}
+
+		if !scanner.Scan() {
+			return fmt.Errorf("receive challenge response: %v", scanErr(scanner))
+		}
+
+		var msg GatherMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("unmarshal challenge response: %v", err)
+		}
+
+		expected := prove(access.Passphrase, nonce, stream.Conn().RemotePeer())
+		if msg.Type != ChallengeResponse || !hmac.Equal(msg.Proof, expected) {
+			err := writeMessage(stream, GatherMessage{Type: AccessDenied})
+			if err != nil {
+				return fmt.Errorf("send access denied: %v", err)
+			}
+
+			return ErrAccessDenied
+		}
+	}
+
+	err = writeMessage(stream, welcome)
+	if err != nil {
+		return fmt.Errorf("send welcome: %v", err)
+	}
+
+	return nil
+}
+
+// enter runs the seeker's side of the handshake and returns the welcome
+// message.
+func enter(stream network.Stream, scanner *bufio.Scanner, passphrase string) (GatherMessage, error) {
+	err := stream.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		return GatherMessage{}, fmt.Errorf("set deadline: %v", err)
+	}
+	defer stream.SetReadDeadline(time.Time{})
+
+	for scanner.Scan() {
+		var msg GatherMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return GatherMessage{}, fmt.Errorf("unmarshal: %v", err)
+		}
+
+		switch msg.Type {
+		case Challenge:
+			proof := prove(passphrase, msg.Nonce, stream.Conn().LocalPeer())
+			err := writeMessage(stream, GatherMessage{Type: ChallengeResponse, Proof: proof})
+			if err != nil {
+				return GatherMessage{}, fmt.Errorf("send challenge response: %v", err)
+			}
+		case AccessDenied:
+			return GatherMessage{}, ErrAccessDenied
+		case Busy:
+			return GatherMessage{}, ErrRoomBusy
+		case Welcome:
+			if msg.Game == "" {
+				return GatherMessage{}, errors.New("welcome without the game ID")
+			}
+			return msg, nil
+		default:
+			return GatherMessage{}, fmt.Errorf("unexpected message of type %d", msg.Type)
+		}
+	}
+
+	return GatherMessage{}, fmt.Errorf("receive welcome: %v", scanErr(scanner))
+}
+
+// scanErr is scanner.Err that does not hide the premature end of stream.
+func scanErr(scanner *bufio.Scanner) error {
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/kuredoro/snake_p2p/core"
 	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 )
@@ -15,17 +16,23 @@ type GatherPointBeacon struct {
 
 	ttl          time.Duration
 	desiredCount int
+	protected    bool
+	room         bool
+	settings     core.GameSettings
 
 	selfInfo peer.AddrInfo
 	topic    *pubsub.Topic
 }
 
-func NewGatherPointBeacon(topic *pubsub.Topic, self peer.AddrInfo, n int, TTL time.Duration) *GatherPointBeacon {
+func NewGatherPointBeacon(topic *pubsub.Topic, self peer.AddrInfo, n int, TTL time.Duration, protected, room bool, settings core.GameSettings) *GatherPointBeacon {
 	b := &GatherPointBeacon{
 		done: make(chan struct{}),
 
 		ttl:          TTL,
 		desiredCount: n,
+		protected:    protected,
+		room:         room,
+		settings:     settings,
 
 		selfInfo: self,
 		topic:    topic,
@@ -74,6 +81,9 @@ func (b *GatherPointBeacon) publish() error {
 		TTL:                b.ttl,
 		DesiredPlayerCount: uint(b.desiredCount),
 		CurrentPlayerCount: 0,
+		Protected:          b.protected,
+		Room:               b.room,
+		Settings:           b.settings,
 	}
 
 	msgBytes, err := json.Marshal(msg)
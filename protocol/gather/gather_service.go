@@ -1,7 +1,6 @@
 package gather
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,6 +16,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
 	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/kuredoro/snake_p2p/protocol/heartbeat"
 
@@ -34,28 +34,51 @@ type GatherService struct {
 	monitorDone, meshUpdateDone chan struct{}
 	done                        bool
 
-	h       host.Host
+	h     host.Host
+	topic *pubsub.Topic
+
+	// mu guards streams and conns. It is held while writing to the
+	// streams, so that the messages do not interleave.
+	mu      sync.Mutex
 	streams map[peer.ID]network.Stream
-	topic   *pubsub.Topic
 
 	ttl          time.Duration
 	desiredCount int
+	access       Access
+	settings     core.GameSettings
 
 	mesh   peerMesh
 	meshCh chan peerMeshMod
 
 	ping             *ping.PingService
 	game             *game.GameService
+	gameID           game.GameID
 	conns            map[peer.ID]*heartbeat.HeartbeatService
 	localConnUpdates chan heartbeat.PeerStatus
 
+	chatLimiter *chat.Limiter
+	chatCh      chan<- chat.Message
+
 	// TODO: move beacon to snake.Node
+	// The beacon is nil for hidden gather points.
 	beacon *GatherPointBeacon
 
 	gameCh chan<- game.GameEstablished
+	// established tells whether the game has been handed over, otherwise
+	// Close ends it. It is only set by meshUpdateLoop.
+	established bool
 }
 
-func NewGatherService(h host.Host, topic *pubsub.Topic, game *game.GameService, ping *ping.PingService, n int, TTL time.Duration, gameCh chan<- game.GameEstablished) (*GatherService, error) {
+func NewGatherService(h host.Host, topic *pubsub.Topic, game *game.GameService, ping *ping.PingService, n int, TTL time.Duration, access Access, settings core.GameSettings, chatCh chan<- chat.Message, gameCh chan<- game.GameEstablished) (*GatherService, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("game settings: %v", err)
+	}
+
+	gameID, err := game.NewGame()
+	if err != nil {
+		return nil, err
+	}
+
 	gs := &GatherService{
 		monitorDone:    make(chan struct{}),
 		meshUpdateDone: make(chan struct{}),
@@ -66,20 +89,28 @@ func NewGatherService(h host.Host, topic *pubsub.Topic, game *game.GameService,
 
 		ttl:          TTL,
 		desiredCount: n,
+		access:       access,
+		settings:     settings,
 
 		mesh:   make(peerMesh),
 		meshCh: make(chan peerMeshMod),
 
 		ping:             ping,
 		game:             game,
+		gameID:           gameID,
 		conns:            make(map[peer.ID]*heartbeat.HeartbeatService),
 		localConnUpdates: make(chan heartbeat.PeerStatus),
 
-		beacon: NewGatherPointBeacon(topic, *HostAddrInfo(h), n, TTL),
+		chatLimiter: chat.NewLimiter(),
+		chatCh:      chatCh,
 
 		gameCh: gameCh,
 	}
 
+	if !access.Hidden {
+		gs.beacon = NewGatherPointBeacon(topic, *HostAddrInfo(h), n, TTL, access.Passphrase != "", false, settings)
+	}
+
 	h.SetStreamHandler(ID, gs.GatherHandler)
 
 	go gs.monitorLoop()
@@ -97,16 +128,31 @@ func (gs *GatherService) GatherHandler(stream network.Stream) {
 	peer := stream.Conn().RemotePeer()
 	log.Info().Str("id", peer.Pretty()).Msg("Seeker connected")
 
+	scanner := newScanner(stream)
+
+	welcome := GatherMessage{
+		Type:     Welcome,
+		Game:     gs.gameID,
+		Settings: &gs.settings,
+	}
+	err := admit(stream, scanner, gs.access, welcome)
+	if err != nil {
+		log.Warn().Err(err).Str("id", peer.Pretty()).Msg("Seeker not admitted")
+		stream.Close()
+		return
+	}
+
 	hb, err := heartbeat.NewHeartbeat(gs.ping, stream.Conn().RemotePeer(), gs.localConnUpdates)
 	if err != nil {
 		panic(err)
 	}
 
+	gs.mu.Lock()
 	gs.streams[peer] = stream
 	gs.conns[peer] = hb
+	gs.mu.Unlock()
 
 	// Proto start
-	scanner := bufio.NewScanner(stream)
 	readCh := make(chan bool)
 	defer close(readCh)
 
@@ -118,8 +164,6 @@ func (gs *GatherService) GatherHandler(stream network.Stream) {
 
 	remotePeer := stream.Conn().RemotePeer()
 
-	// TODO: writing to streams should probably be done from this function
-	// for synchronization purposes, but maybe stream.Write is thread-safe...
 	for ok := range readCh {
 		if !ok {
 			log.Info().Str("id", peer.Pretty()).Msg("Seeker withdrawn")
@@ -163,6 +207,22 @@ func (gs *GatherService) GatherHandler(stream network.Stream) {
 				Msg("Seeker-seeker connection reset")
 
 			gs.meshCh <- removeDoubleEdge(remotePeer, msg.Addrs[0].ID)
+		case Chat:
+			err := gs.relayChat(remotePeer, msg.Text)
+			if err != nil {
+				log.Warn().Err(err).
+					Str("seeker", remotePeer.Pretty()).
+					Msg("Chat message rejected")
+			}
+		case MapRequest:
+			gs.mu.Lock()
+			err := sendMap(stream, gs.settings, msg.Map)
+			gs.mu.Unlock()
+			if err != nil {
+				log.Warn().Err(err).
+					Str("seeker", remotePeer.Pretty()).
+					Msg("Send map")
+			}
 		default:
 			log.Warn().
 				Str("seeker", remotePeer.Pretty()).
@@ -208,8 +268,10 @@ func (gs *GatherService) meshUpdateLoop() {
 			}
 
 			msg := GatherMessage{
-				Type:  GatheringFinished,
-				Addrs: addrs,
+				Type:     GatheringFinished,
+				Addrs:    addrs,
+				Settings: &gs.settings,
+				Game:     gs.gameID,
 			}
 
 			raw, err := json.Marshal(&msg)
@@ -219,6 +281,7 @@ func (gs *GatherService) meshUpdateLoop() {
 
 			raw = append(raw, '\n')
 
+			gs.mu.Lock()
 			var wg sync.WaitGroup
 			wg.Add(len(gs.streams))
 			for id, stream := range gs.streams {
@@ -233,18 +296,22 @@ func (gs *GatherService) meshUpdateLoop() {
 
 			wg.Wait()
 
-			gs.closeHeartbeats()
-
 			for id := range gs.streams {
 				// JoinService will close the stream itself
 				// TODO: delete loop or create new? Does it even matter,
 				// this service should be garbage collected...
 				delete(gs.streams, id)
 			}
+			gs.mu.Unlock()
 
+			gs.closeHeartbeats()
+
+			gs.established = true
 			gs.gameCh <- game.GameEstablished{
+				ID:          gs.gameID,
 				Facilitator: gs.h.ID(),
-				Game:        gs.game.GetInstance(),
+				Game:        gs.game.Instance(gs.gameID),
+				Settings:    gs.settings,
 			}
 		}
 	}
@@ -259,7 +326,7 @@ func (gs *GatherService) monitorLoop() {
 		case peerStatus := <-gs.localConnUpdates:
 			switch peerStatus.Alive {
 			case true:
-				err := gs.game.Connect(context.Background(), peerStatus.Peer)
+				err := gs.game.Connect(context.Background(), gs.gameID, peerStatus.Peer)
 				if err != nil {
 					log.Err(err).
 						Str("peer", peerStatus.Peer.Pretty()).
@@ -285,7 +352,7 @@ func (gs *GatherService) monitorLoop() {
 					}
 				}
 			case false:
-				gs.game.Disconnect(peerStatus.Peer)
+				gs.game.Disconnect(gs.gameID, peerStatus.Peer)
 
 				gs.meshCh <- removeDoubleEdge(gs.h.ID(), peerStatus.Peer)
 
@@ -305,20 +372,29 @@ func (gs *GatherService) monitorLoop() {
 }
 
 func (gs *GatherService) peerDisconnected(p peer.ID) {
-	if _, connected := gs.streams[p]; !connected {
+	gs.mu.Lock()
+	stream, connected := gs.streams[p]
+	if !connected {
+		gs.mu.Unlock()
 		return
 	}
 
-	gs.streams[p].Close()
+	stream.Close()
 	delete(gs.streams, p)
 
-	gs.conns[p].Close()
+	hb := gs.conns[p]
 	delete(gs.conns, p)
+	gs.mu.Unlock()
+
+	hb.Close()
 
 	gs.meshCh <- removePeer(p)
 }
 
 func (gs *GatherService) askEverybodyToConnectTo(peer peer.ID) (merr error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
 	errCh := make(chan error)
 
 	msgCount := 0
@@ -397,11 +473,58 @@ func (gs *GatherService) askPeerToConnectTo(stream network.Stream, pi peer.AddrI
 	return nil
 }
 
+// SendChat sends the text to everybody who joined the gather point.
+func (gs *GatherService) SendChat(text string) error {
+	return gs.relayChat(gs.h.ID(), text)
+}
+
+// relayChat passes a chat message of one of the gather point members to
+// all the others. The facilitator is the one who enforces the limits.
+func (gs *GatherService) relayChat(from peer.ID, text string) error {
+	if err := chat.Validate(text); err != nil {
+		return err
+	}
+
+	if !gs.chatLimiter.Allow(from) {
+		return chat.ErrRateLimited
+	}
+
+	msg := GatherMessage{
+		Type: Chat,
+		From: from,
+		Text: text,
+	}
+
+	gs.mu.Lock()
+	for id, stream := range gs.streams {
+		err := writeMessage(stream, msg)
+		if err != nil {
+			log.Err(err).Str("seeker", id.Pretty()).Msg("Relay chat message")
+		}
+	}
+	gs.mu.Unlock()
+
+	chat.Deliver(gs.chatCh, chat.Message{
+		Scope: chat.GatherPoint,
+		From:  from,
+		Text:  text,
+	})
+
+	return nil
+}
+
+// closeHeartbeats stops the heartbeats, without holding the lock while
+// they finish.
 func (gs *GatherService) closeHeartbeats() {
+	gs.mu.Lock()
+	conns := gs.conns
+	gs.conns = make(map[peer.ID]*heartbeat.HeartbeatService)
+	gs.mu.Unlock()
+
 	var wg sync.WaitGroup
-	wg.Add(len(gs.conns))
+	wg.Add(len(conns))
 
-	for _, hb := range gs.conns {
+	for _, hb := range conns {
 		go func(hb *heartbeat.HeartbeatService) {
 			hb.Close()
 			wg.Done()
@@ -409,10 +532,17 @@ func (gs *GatherService) closeHeartbeats() {
 	}
 
 	wg.Wait()
+}
 
-	for id := range gs.conns {
-		delete(gs.conns, id)
-	}
+// OpenRoom keeps the gathered game open for newcomers between the rounds,
+// with the same access and settings. The gather service must be closed.
+func (gs *GatherService) OpenRoom() *RoomService {
+	return NewRoomService(gs.h, gs.topic, gs.game, gs.gameID, gs.desiredCount, gs.ttl, gs.access, gs.settings)
+}
+
+// GameID returns the ID of the game being gathered.
+func (gs *GatherService) GameID() game.GameID {
+	return gs.gameID
 }
 
 func (gs *GatherService) Close() {
@@ -422,13 +552,22 @@ func (gs *GatherService) Close() {
 	gs.meshUpdateDone <- struct{}{}
 	<-gs.meshUpdateDone
 
-	gs.beacon.Close()
+	if gs.beacon != nil {
+		gs.beacon.Close()
+	}
 
 	gs.closeHeartbeats()
 
 	close(gs.localConnUpdates)
 
+	gs.mu.Lock()
 	for _, s := range gs.streams {
 		s.Close()
 	}
+	gs.mu.Unlock()
+
+	// The game that will not take place is not kept around.
+	if !gs.established {
+		gs.game.End(gs.gameID)
+	}
 }
@@ -1,3 +1,3 @@
 package gather
 
-const ID = "/snake/gather/0.1.0"
+const ID = "/snake/gather/0.2.0"
@@ -0,0 +1,66 @@
+package gather
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const InviteScheme = "snake://"
+
+// FormatInvite encodes the facilitator's address info into an invite
+// string of the form snake://<peer id>@<multiaddr>,<multiaddr>,...
+func FormatInvite(pi peer.AddrInfo) string {
+	var str strings.Builder
+
+	str.WriteString(InviteScheme)
+	str.WriteString(pi.ID.Pretty())
+	str.WriteRune('@')
+
+	for i, addr := range pi.Addrs {
+		if i != 0 {
+			str.WriteRune(',')
+		}
+		str.WriteString(addr.String())
+	}
+
+	return str.String()
+}
+
+// ParseInvite decodes an invite produced by FormatInvite.
+func ParseInvite(invite string) (peer.AddrInfo, error) {
+	invite = strings.TrimSpace(invite)
+	if !strings.HasPrefix(invite, InviteScheme) {
+		return peer.AddrInfo{}, fmt.Errorf("invite must start with %s", InviteScheme)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(invite, InviteScheme), "@", 2)
+
+	id, err := peer.Decode(parts[0])
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("decode peer ID: %v", err)
+	}
+
+	pi := peer.AddrInfo{ID: id}
+	if len(parts) == 1 {
+		return pi, nil
+	}
+
+	for _, addr := range strings.Split(parts[1], ",") {
+		if addr == "" {
+			continue
+		}
+
+		// Reuse libp2p's parser, it validates the address and
+		// that it points to the same peer.
+		info, err := peer.AddrInfoFromString(addr + "/p2p/" + parts[0])
+		if err != nil {
+			return peer.AddrInfo{}, fmt.Errorf("parse address %q: %v", addr, err)
+		}
+
+		pi.Addrs = append(pi.Addrs, info.Addrs...)
+	}
+
+	return pi, nil
+}
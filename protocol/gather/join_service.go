@@ -5,7 +5,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/chat"
 	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/kuredoro/snake_p2p/protocol/heartbeat"
 	"github.com/libp2p/go-libp2p-core/host"
@@ -16,22 +19,44 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// JoinFailed tells that the game of the gather point we have joined will
+// not take place for us, and why.
+type JoinFailed struct {
+	Facilitator peer.ID
+	Err         error
+}
+
 type JoinService struct {
 	done chan struct{}
 
 	h            host.Host
 	ping         *ping.PingService
 	game         *game.GameService
+	gameID       game.GameID
+	settings     core.GameSettings
+	mapReady     chan struct{}
 	stream       network.Stream
+	scanner      *bufio.Scanner
 	conns        map[peer.ID]*heartbeat.HeartbeatService
 	connHealthCh chan heartbeat.PeerStatus
 
 	log zerolog.Logger
 
+	chatCh chan<- chat.Message
 	gameCh chan<- game.GameEstablished
+	failCh chan<- JoinFailed
+
+	// room tells whether we have entered a room, whose facilitator hangs
+	// up right after the welcome.
+	room bool
+
+	// established tells whether the game has been handed over, otherwise
+	// Close ends it.
+	mu          sync.Mutex
+	established bool
 }
 
-func NewJoinService(ctx context.Context, h host.Host, game *game.GameService, ping *ping.PingService, pID peer.ID, gameCh chan<- game.GameEstablished) (*JoinService, error) {
+func NewJoinService(ctx context.Context, h host.Host, game *game.GameService, ping *ping.PingService, pID peer.ID, passphrase string, chatCh chan<- chat.Message, gameCh chan<- game.GameEstablished, failCh chan<- JoinFailed) (*JoinService, error) {
 	stream, err := h.NewStream(ctx, pID, ID)
 	if err != nil {
 		return nil, fmt.Errorf("create gather protocol stream: %v", err)
@@ -43,6 +68,24 @@ func NewJoinService(ctx context.Context, h host.Host, game *game.GameService, pi
 		return nil, fmt.Errorf("force new gather protocol stream: %v", err)
 	}
 
+	scanner := newScanner(stream)
+
+	welcome, err := enter(stream, scanner, passphrase)
+	if err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("enter gather point: %w", err)
+	}
+	gameID := welcome.Game
+
+	mapReady, err := requestMap(stream, welcome.Settings)
+	if err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	// The others can connect to us as soon as we are let in.
+	game.Instance(gameID)
+
 	logger := log.Logger.With().Str("facilitator", pID.Pretty()).Logger()
 
 	service := &JoinService{
@@ -51,22 +94,38 @@ func NewJoinService(ctx context.Context, h host.Host, game *game.GameService, pi
 		h:            h,
 		ping:         ping,
 		game:         game,
+		gameID:       gameID,
+		mapReady:     mapReady,
 		stream:       stream,
+		scanner:      scanner,
 		conns:        make(map[peer.ID]*heartbeat.HeartbeatService),
 		connHealthCh: make(chan heartbeat.PeerStatus),
 
 		log: logger,
 
+		chatCh: chatCh,
 		gameCh: gameCh,
+		failCh: failCh,
+
+		room: len(welcome.Addrs) > 0 && welcome.Settings != nil,
+	}
+
+	if welcome.Settings != nil {
+		service.settings = *welcome.Settings
 	}
 
 	go service.run()
 
+	// A room is past the gathering, we connect to its players ourselves.
+	if service.room {
+		go service.enterRoom(welcome)
+	}
+
 	return service, nil
 }
 
 func (js *JoinService) run() {
-	scanner := bufio.NewScanner(js.stream)
+	scanner := js.scanner
 	readCh := make(chan bool)
 	defer close(readCh)
 
@@ -109,7 +168,7 @@ func (js *JoinService) run() {
 				// UPD: I've deleted errCh, why do we even use it?
 				// And in GatherService we use _no_ goroutines...
 				// UPD2: I've removed goroutines...
-				err := js.game.Connect(context.Background(), status.Peer)
+				err := js.game.Connect(context.Background(), js.gameID, status.Peer)
 				if err != nil {
 					js.log.Err(err).
 						Str("seeker", status.Peer.Pretty()).
@@ -132,7 +191,7 @@ func (js *JoinService) run() {
 					Str("seeker", status.Peer.Pretty()).
 					Msg("Peer seeker game connection reset")
 
-				js.game.Disconnect(status.Peer)
+				js.game.Disconnect(js.gameID, status.Peer)
 
 				err := js.sendDisconnected(status.Peer)
 				if err != nil {
@@ -149,6 +208,11 @@ func (js *JoinService) run() {
 					js.log.Err(err).Msg("Close stream")
 				}
 
+				// The gather point is gone, and so is its game.
+				if !js.room {
+					js.game.End(js.gameID)
+				}
+
 				// Do not scan() again
 				continue
 			}
@@ -205,17 +269,74 @@ func (js *JoinService) run() {
 				reading = false
 
 				if !foundMyself {
+					js.game.End(js.gameID)
+					continue
+				}
+
+				// The game will not take place without its map or
+				// valid settings.
+				if msg.Game != js.gameID {
+					js.log.Error().
+						Str("game", string(msg.Game)).
+						Str("expected", string(js.gameID)).
+						Msg("Gathering finished for another game")
+					js.game.End(js.gameID)
+					continue
+				}
+
+				if msg.Settings == nil {
+					js.log.Error().Msg("Gathering finished without game settings")
+					js.game.End(js.gameID)
+					continue
+				}
+
+				if err := msg.Settings.Validate(); err != nil {
+					js.log.Err(err).Msg("Facilitator chose invalid game settings")
+					js.game.End(js.gameID)
+					continue
+				}
+
+				if _, known := core.Maps.Get(msg.Settings.Map); msg.Settings.Map != "" && !known {
+					js.log.Error().Str("map", msg.Settings.Map).Msg("Chosen for a game without its map")
+					js.game.End(js.gameID)
 					continue
 				}
 
 				js.log.Info().
 					Msg("Chosen for a game")
 
+				js.establish()
 				js.gameCh <- game.GameEstablished{
+					ID:          js.gameID,
 					Facilitator: js.stream.Conn().RemotePeer(),
-					Game:        js.game.GetInstance(),
+					Game:        js.game.Instance(js.gameID),
+					Settings:    *msg.Settings,
 				}
 				continue
+			case MapData:
+				if err := receiveMap(msg, js.settings.Map); err != nil {
+					js.log.Warn().Err(err).Msg("Received bad map")
+					break
+				}
+
+				select {
+				case <-js.mapReady:
+				default:
+					close(js.mapReady)
+				}
+			case Chat:
+				if err := chat.Validate(msg.Text); err != nil {
+					js.log.Warn().Err(err).
+						Str("from", msg.From.Pretty()).
+						Msg("Received invalid chat message")
+					break
+				}
+
+				chat.Deliver(js.chatCh, chat.Message{
+					Scope: chat.GatherPoint,
+					From:  msg.From,
+					Text:  msg.Text,
+				})
 			default:
 				js.log.Warn().
 					Int("type", int(msg.Type)).
@@ -234,9 +355,43 @@ func (js *JoinService) closeHeartbeats() {
 	}
 }
 
+// GameID returns the ID of the game being gathered.
+func (js *JoinService) GameID() game.GameID {
+	return js.gameID
+}
+
 func (js *JoinService) Close() {
 	js.done <- struct{}{}
 	<-js.done
+
+	// Unless we have been chosen for the game, or have entered the room,
+	// the instance has no use.
+	js.mu.Lock()
+	established := js.established
+	js.mu.Unlock()
+	if !established {
+		js.game.End(js.gameID)
+	}
+}
+
+// establish marks the game as handed over to the node.
+func (js *JoinService) establish() {
+	js.mu.Lock()
+	js.established = true
+	js.mu.Unlock()
+}
+
+// SendChat sends the text to the facilitator, who relays it to the
+// gather point members, us included.
+func (js *JoinService) SendChat(text string) error {
+	if err := chat.Validate(text); err != nil {
+		return err
+	}
+
+	return writeMessage(js.stream, GatherMessage{
+		Type: Chat,
+		Text: text,
+	})
 }
 
 func (js *JoinService) sendConnected(p peer.ID) error {
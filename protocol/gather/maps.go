@@ -0,0 +1,90 @@
+package gather
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// sendMap sends the map of the game to the seeker that lacks it. Only the
+// map the game is played on is given away.
+func sendMap(w io.Writer, settings core.GameSettings, hash string) error {
+	if hash == "" || hash != settings.Map {
+		return fmt.Errorf("map %q is not the game's", hash)
+	}
+
+	raw, exists := core.Maps.Raw(hash)
+	if !exists {
+		return fmt.Errorf("map %s is missing", hash)
+	}
+
+	return writeMessage(w, GatherMessage{
+		Type: MapData,
+		Map:  hash,
+		Raw:  raw,
+	})
+}
+
+// serveMap answers the map request of the room's newcomer, if they send
+// one right after the welcome.
+func serveMap(stream network.Stream, scanner *bufio.Scanner, settings core.GameSettings) error {
+	err := stream.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		return fmt.Errorf("set deadline: %v", err)
+	}
+	defer stream.SetReadDeadline(time.Time{})
+
+	if !scanner.Scan() {
+		// The newcomer has the map.
+		return nil
+	}
+
+	var msg GatherMessage
+	if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	if msg.Type != MapRequest {
+		return fmt.Errorf("unexpected message of type %d", msg.Type)
+	}
+
+	return sendMap(stream, settings, msg.Map)
+}
+
+// requestMap asks the facilitator for the map of the game if we do not
+// have it. The returned channel is closed once we do.
+func requestMap(w io.Writer, settings *core.GameSettings) (chan struct{}, error) {
+	ready := make(chan struct{})
+	if settings == nil || settings.Map == "" {
+		close(ready)
+		return ready, nil
+	}
+
+	if _, known := core.Maps.Get(settings.Map); known {
+		close(ready)
+		return ready, nil
+	}
+
+	err := writeMessage(w, GatherMessage{Type: MapRequest, Map: settings.Map})
+	if err != nil {
+		return nil, fmt.Errorf("request map: %v", err)
+	}
+
+	return ready, nil
+}
+
+// receiveMap keeps the map the facilitator has sent, if it is the one we
+// have asked for.
+func receiveMap(msg GatherMessage, expected string) error {
+	if msg.Map != expected || core.MapHash(msg.Raw) != expected {
+		return fmt.Errorf("received map is not %s", expected)
+	}
+
+	_, err := core.Maps.Add("", msg.Raw)
+	return err
+}
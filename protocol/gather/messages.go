@@ -1,8 +1,14 @@
 package gather
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
 	"time"
 
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/protocol/game"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
@@ -13,6 +19,14 @@ const (
 	Connected
 	Disconnected
 	GatheringFinished
+	Challenge
+	ChallengeResponse
+	AccessDenied
+	Welcome
+	Chat
+	Busy
+	MapRequest
+	MapData
 )
 
 type GatherPointMessage struct {
@@ -20,6 +34,9 @@ type GatherPointMessage struct {
 	TTL                time.Duration
 	DesiredPlayerCount uint
 	CurrentPlayerCount uint
+	Protected          bool // seekers need a passphrase to join
+	Room               bool // the game is on, seekers join between rounds
+	Settings           core.GameSettings
 }
 
 // GatherMessage represents a set of all different messages
@@ -31,5 +48,45 @@ type GatherPointMessage struct {
 // (TODO: consider protobuf).
 type GatherMessage struct {
 	Type  GatherMessageType
-	Addrs []peer.AddrInfo
+	Addrs []peer.AddrInfo `json:",omitempty"`
+	Nonce []byte          `json:",omitempty"` // Challenge
+	Proof []byte          `json:",omitempty"` // ChallengeResponse
+	From  peer.ID         `json:",omitempty"` // Chat, filled by the facilitator
+	Text  string          `json:",omitempty"` // Chat
+
+	Settings *core.GameSettings `json:",omitempty"` // GatheringFinished, Welcome
+	Game     game.GameID        `json:",omitempty"` // Welcome, GatheringFinished
+	Round    int                `json:",omitempty"` // Welcome to a room
+	Map      string             `json:",omitempty"` // MapRequest, MapData, the content hash
+	Raw      []byte             `json:",omitempty"` // MapData, the map file
+
+	// Standings are sent in the order of the ranking, refer to standing.
+	Standings []standing `json:",omitempty"` // Welcome to a room
+}
+
+// maxMessageSize bounds a gather message. The largest is MapData, whose
+// map grows by a third in base64.
+const maxMessageSize = core.MaxMapSize*4/3 + 4<<10
+
+// newScanner reads the gather messages off the stream, one per line.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4<<10), maxMessageSize)
+	return scanner
+}
+
+func writeMessage(w io.Writer, msg GatherMessage) error {
+	raw, err := json.Marshal(&msg)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	raw = append(raw, '\n')
+
+	_, err = w.Write(raw)
+	if err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+
+	return nil
 }
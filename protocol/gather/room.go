@@ -0,0 +1,265 @@
+package gather
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/rs/zerolog/log"
+
+	"github.com/kuredoro/snake_p2p/core"
+	"github.com/kuredoro/snake_p2p/engine/rules"
+	"github.com/kuredoro/snake_p2p/protocol/game"
+)
+
+// RoomDialAttempts is how many times a newcomer tries to connect to each
+// player of the room. The players accept the newcomer once they are done
+// with the round.
+var RoomDialAttempts = 10
+
+// RoomReservation is how long the slot of an admitted newcomer is kept
+// for them, until they connect to the players.
+var RoomReservation = 30 * time.Second
+
+var errNoRoomMap = errors.New("room's map has not arrived")
+
+// RoomService keeps the game of a series of rounds open for newcomers.
+// It is run by the facilitator once the gathering is over, and lets the
+// seekers in between the rounds, as long as there is room for them.
+// Those who are let in connect to the players themselves and play from
+// the next round on.
+type RoomService struct {
+	h        host.Host
+	gi       *game.GameInstance
+	gameID   game.GameID
+	size     int
+	access   Access
+	settings core.GameSettings
+
+	// reserved are the newcomers that have been admitted and have not
+	// connected to us yet, with the time they were admitted.
+	mu       sync.Mutex
+	reserved map[peer.ID]time.Time
+
+	// The beacon is nil for hidden rooms.
+	beacon *GatherPointBeacon
+}
+
+func NewRoomService(h host.Host, topic *pubsub.Topic, games *game.GameService, id game.GameID, n int, TTL time.Duration, access Access, settings core.GameSettings) *RoomService {
+	rs := &RoomService{
+		h:        h,
+		gi:       games.Instance(id),
+		gameID:   id,
+		size:     n,
+		access:   access,
+		settings: settings,
+		reserved: make(map[peer.ID]time.Time),
+	}
+
+	if !access.Hidden {
+		rs.beacon = NewGatherPointBeacon(topic, *HostAddrInfo(h), n, TTL, access.Passphrase != "", true, settings)
+	}
+
+	h.SetStreamHandler(ID, rs.RoomHandler)
+
+	return rs
+}
+
+// RoomHandler admits the seeker and tells them who to connect to. The
+// seeker's slot is reserved from then on, so that the seekers let in at
+// the same time do not overfill the room.
+func (rs *RoomService) RoomHandler(stream network.Stream) {
+	defer stream.Close()
+
+	p := stream.Conn().RemotePeer()
+	players := rs.gi.PlayersIDs()
+
+	rs.mu.Lock()
+	taken := rs.taken(players)
+	if rs.gi.Running() || taken >= rs.size {
+		rs.mu.Unlock()
+
+		log.Info().
+			Str("id", p.Pretty()).
+			Int("players", taken).
+			Msg("Room is busy")
+
+		err := writeMessage(stream, GatherMessage{Type: Busy})
+		if err != nil {
+			log.Err(err).Str("id", p.Pretty()).Msg("Send busy")
+		}
+		return
+	}
+	rs.reserved[p] = time.Now()
+	rs.mu.Unlock()
+
+	addrs := make([]peer.AddrInfo, 0, len(players))
+	for _, id := range players {
+		addrs = append(addrs, rs.h.Peerstore().PeerInfo(id))
+	}
+
+	settings := rs.settings
+	welcome := GatherMessage{
+		Type:     Welcome,
+		Game:     rs.gameID,
+		Addrs:    addrs,
+		Settings: &settings,
+		Round:    rs.gi.Round(),
+
+		Standings: standingsToWire(rs.gi.Standings()),
+	}
+
+	scanner := newScanner(stream)
+	err := admit(stream, scanner, rs.access, welcome)
+	if err != nil {
+		log.Warn().Err(err).Str("id", p.Pretty()).Msg("Seeker not admitted to the room")
+
+		rs.mu.Lock()
+		delete(rs.reserved, p)
+		rs.mu.Unlock()
+		return
+	}
+
+	log.Info().
+		Str("id", p.Pretty()).
+		Int("round", welcome.Round).
+		Msg("Seeker admitted to the room")
+
+	if rs.settings.Map != "" {
+		if err := serveMap(stream, scanner, rs.settings); err != nil {
+			log.Warn().Err(err).Str("id", p.Pretty()).Msg("Send map to the newcomer")
+		}
+	}
+}
+
+// taken counts the players and the reserved slots. The reservations of the
+// newcomers who have connected and the expired ones are dropped. Must be
+// called with the lock held.
+func (rs *RoomService) taken(players []peer.ID) int {
+	playing := make(map[peer.ID]bool, len(players))
+	for _, id := range players {
+		playing[id] = true
+	}
+
+	n := len(players)
+	for id, at := range rs.reserved {
+		if playing[id] || time.Since(at) > RoomReservation {
+			delete(rs.reserved, id)
+			continue
+		}
+		n++
+	}
+
+	return n
+}
+
+// standing is the score of a player of a room on the wire. The standings
+// travel as a list of them, the binary peer.ID does not make a JSON
+// object key.
+type standing struct {
+	ID peer.ID
+	rules.Score
+}
+
+func standingsToWire(s rules.Standings) []standing {
+	wire := make([]standing, 0, len(s))
+	for _, id := range s.Ranking() {
+		wire = append(wire, standing{ID: id, Score: *s[id]})
+	}
+
+	return wire
+}
+
+func standingsFromWire(wire []standing) rules.Standings {
+	s := make(rules.Standings, len(wire))
+	for _, w := range wire {
+		score := w.Score
+		s[w.ID] = &score
+	}
+
+	return s
+}
+
+// GameID returns the ID of the room's game.
+func (rs *RoomService) GameID() game.GameID {
+	return rs.gameID
+}
+
+func (rs *RoomService) Close() {
+	rs.h.RemoveStreamHandler(ID)
+
+	if rs.beacon != nil {
+		rs.beacon.Close()
+	}
+}
+
+// enterRoom connects to the players of the room we were let in and
+// reports the game. We play from the round the room is about to start.
+// If we cannot, the game is ended and the failure reported instead.
+func (js *JoinService) enterRoom(welcome GatherMessage) {
+	if err := welcome.Settings.Validate(); err != nil {
+		js.fail(fmt.Errorf("room has invalid game settings: %w", err))
+		return
+	}
+
+	select {
+	case <-js.mapReady:
+	case <-time.After(HandshakeTimeout):
+		js.fail(fmt.Errorf("%w: %s", errNoRoomMap, welcome.Settings.Map))
+		return
+	}
+
+	gi := js.game.Instance(js.gameID)
+	gi.SetRound(welcome.Round)
+	gi.SetStandings(standingsFromWire(welcome.Standings))
+
+	for _, pi := range welcome.Addrs {
+		if pi.ID == js.h.ID() {
+			continue
+		}
+		js.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+
+		var err error
+		for i := 0; i < RoomDialAttempts; i++ {
+			if err = js.game.Connect(context.Background(), js.gameID, pi.ID); err == nil {
+				break
+			}
+			time.Sleep(game.RedialEvery)
+		}
+		if err != nil {
+			js.log.Err(err).
+				Str("player", pi.ID.Pretty()).
+				Msg("Connect to the room player")
+		}
+	}
+
+	js.log.Info().
+		Int("round", welcome.Round).
+		Msg("Entered the room")
+
+	js.establish()
+	js.gameCh <- game.GameEstablished{
+		ID:          js.gameID,
+		Facilitator: js.stream.Conn().RemotePeer(),
+		Game:        gi,
+		Settings:    *welcome.Settings,
+	}
+}
+
+// fail ends the game of the room we could not enter and reports why.
+func (js *JoinService) fail(err error) {
+	js.log.Err(err).Msg("Enter the room")
+
+	js.game.End(js.gameID)
+	js.failCh <- JoinFailed{
+		Facilitator: js.stream.Conn().RemotePeer(),
+		Err:         err,
+	}
+}